@@ -8,8 +8,14 @@ import (
 
 type AnketaRepository interface {
 	Create(ctx context.Context, anketa Anketa) error
-	Update(ctx context.Context, id uuid.UUID, update map[string]any) error
+	// Update вместе с изменением записывает changes в историю
+	Update(ctx context.Context, id uuid.UUID, update map[string]any, expectedVersion int64, changes []ChangeRecord) error
+	AddLike(ctx context.Context, id uuid.UUID, likerId uuid.UUID) (bool, error)
 	Delete(ctx context.Context, id uuid.UUID) error
 	FindByID(ctx context.Context, id uuid.UUID) (Anketa, error)
 	GetAnketas(ctx context.Context, pref PreferredAnketaGender, id uuid.UUID) ([]Anketa, error)
+	SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error
+	SetAccountHidden(ctx context.Context, id uuid.UUID, hidden bool) error
+	FindLikedBy(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	RemoveLikesBy(ctx context.Context, likerId uuid.UUID) error
 }
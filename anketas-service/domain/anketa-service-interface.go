@@ -16,10 +16,15 @@ type AnketaService interface {
 		description string,
 		tags []string,
 		photos []string,
-		likedBy []string,
 	) (uuid.UUID, error)
 	GetAnketaByID(ctx context.Context, id uuid.UUID) (Anketa, error)
 	Delete(ctx context.Context, id uuid.UUID) error
-	Update(ctx context.Context, update map[string]any) error
+	Update(ctx context.Context, update map[string]any, expectedVersion int64, actor string) error
+	AddLike(ctx context.Context, id uuid.UUID, likerId uuid.UUID) (bool, error)
 	GetAnketas(ctx context.Context, pref PreferredAnketaGender, id uuid.UUID) ([]Anketa, error)
+	SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error
+	SetAccountHidden(ctx context.Context, id uuid.UUID, hidden bool) error
+	GetLikedBy(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	RemoveLikesBy(ctx context.Context, likerId uuid.UUID) error
+	GetHistory(ctx context.Context, id uuid.UUID, before string, limit int) ([]ChangeRecord, error)
 }
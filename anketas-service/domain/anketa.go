@@ -100,4 +100,10 @@ type Anketa struct {
 	Tags            []Tag
 	Photos          []Photo
 	LikedBy         []uuid.UUID
+	// скрытая администратором анкета не попадает в подбор
+	Hidden bool
+	// анкета деактивированного аккаунта тоже не попадает в подбор; флаг модерации при этом не трогается
+	AccountHidden bool
+	// растет при каждом изменении; отдается клиенту как ETag
+	Version int64
 }
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChangeRecord - запись истории изменений анкеты: одно поле в одном обновлении
+type ChangeRecord struct {
+	ID        string
+	AnketaID  uuid.UUID
+	Field     string
+	OldValue  any
+	NewValue  any
+	Actor     string
+	Version   int64
+	ChangedAt time.Time
+}
+
+// NewChangeRecords описывает обновление анкеты old полями update от имени actor; неизменившиеся поля пропускаются
+func NewChangeRecords(old Anketa, update map[string]any, actor string, version int64) []ChangeRecord {
+	fields := make([]string, 0, len(update))
+	for field := range update {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	now := time.Now()
+	var records []ChangeRecord
+	for _, field := range fields {
+		newValue := update[field]
+		oldValue := old.FieldValue(field)
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		records = append(records, ChangeRecord{
+			AnketaID:  old.ID,
+			Field:     field,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			Actor:     actor,
+			Version:   version,
+			ChangedAt: now,
+		})
+	}
+	return records
+}
+
+// FieldValue возвращает текущее значение поля анкеты в том виде, в каком оно приходит в обновлении
+func (a Anketa) FieldValue(field string) any {
+	switch field {
+	case "username":
+		return a.Username.Value
+	case "age":
+		return a.Age.Int()
+	case "gender":
+		return a.Gender.Value
+	case "preferred_gender":
+		return a.PreferredGender.Value
+	case "description":
+		return a.Description
+	case "tags":
+		tags := make([]string, 0, len(a.Tags))
+		for _, tag := range a.Tags {
+			tags = append(tags, tag.Value)
+		}
+		return tags
+	case "photos":
+		photos := make([]string, 0, len(a.Photos))
+		for _, photo := range a.Photos {
+			photos = append(photos, photo.Url)
+		}
+		return photos
+	}
+	return nil
+}
+
+// HistoryRepository читает историю изменений; записи добавляет AnketaRepository.Update вместе с самим изменением
+type HistoryRepository interface {
+	// FindByAnketa отдает записи от новых к старым, начиная с записи перед before (пустой - с последней)
+	FindByAnketa(ctx context.Context, id uuid.UUID, before string, limit int) ([]ChangeRecord, error)
+}
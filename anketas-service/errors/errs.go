@@ -16,6 +16,12 @@ var ErrInvalidTag = errors.New("некорректно указан тег")
 
 var ErrInvalidPhoto = errors.New("некорректная ссылка на фото")
 
+var ErrAnketaNotFound = errors.New("анкета не найдена")
+
+var ErrVersionConflict = errors.New("анкета уже изменена, обновите ее и повторите")
+
+var ErrInvalidHistoryCursor = errors.New("неверный курсор истории изменений")
+
 //
 // ошибки сервера
 var InternalServerError = errors.New("Произошла ошибка на стороне сервера, попробуйте еще раз позже")
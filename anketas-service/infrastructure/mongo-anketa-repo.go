@@ -4,25 +4,44 @@ import (
 	"anketas-service/domain"
 	errs "anketas-service/errors"
 	"anketas-service/valueObjects"
+	"auth-kit/mongokit"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"sort"
+	"time"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type MongoAnketaRepo struct {
 	collection *mongo.Collection
+	history    *mongokit.History
 }
 
 func NewAnketaRepo(db *mongo.Client) *MongoAnketaRepo {
-	return &MongoAnketaRepo{
+	repo := &MongoAnketaRepo{
 		db.Database("main").Collection("anketas"),
+		newAnketaHistory(db.Database("main")),
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// все запросы ищут анкету по id, поэтому он должен быть уникальным и проиндексированным
+	_, err := repo.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("id_unique"),
+	})
+	if err != nil {
+		log.Fatalln("Не удалось создать индекс анкет по id", err)
+	}
+
+	return repo
 }
 
 type anketaDTO struct {
@@ -35,6 +54,9 @@ type anketaDTO struct {
 	Tags            []string `bson:"tags"`
 	Photos          []string `bson:"photos"`
 	LikedBy         []string `bson:"liked_by"`
+	Hidden          bool     `bson:"hidden"`
+	AccountHidden   bool     `bson:"account_hidden"`
+	Version         int64    `bson:"version"`
 }
 
 const AGE_DIFFERENCE = 2
@@ -66,6 +88,7 @@ func (r *MongoAnketaRepo) Create(ctx context.Context, anketa domain.Anketa) erro
 		"tags":             tags,
 		"photos":           photos,
 		"liked_by":         likedBy,
+		"version":          1,
 	}
 
 	_, err := r.collection.InsertOne(ctx, doc)
@@ -79,28 +102,89 @@ func (r *MongoAnketaRepo) Create(ctx context.Context, anketa domain.Anketa) erro
 	return nil
 }
 
-func (r *MongoAnketaRepo) Update(ctx context.Context, id uuid.UUID, updateData map[string]any) error {
+// Update применяет изменения, только если анкета все еще в версии expectedVersion.
+// Изменение и записи истории пишутся в одной транзакции (см. mongokit.RequireTransactions)
+func (r *MongoAnketaRepo) Update(ctx context.Context, id uuid.UUID, updateData map[string]any, expectedVersion int64, changes []domain.ChangeRecord) error {
 
 	log.Println("Репозиторий начал обновление анкеты")
-	filter := bson.M{"id": id.String()}
-	update := bson.M{"$set": updateData}
+	filter := bson.M{"id": id.String(), "version": mongokit.VersionFilter(expectedVersion)}
+	update := bson.M{"$set": updateData, "$inc": bson.M{"version": 1}}
 
-	result, err := r.collection.UpdateOne(ctx, filter, update)
+	session, err := r.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	matched, err := session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		result, err := r.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 || len(changes) == 0 {
+			return result.MatchedCount > 0, nil
+		}
+		if err := r.history.Insert(ctx, historyRecords(changes)); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
 	if err != nil {
 		log.Println("Не удалось обновить анкету", err)
 		return err
 	}
 
-	if result.MatchedCount == 0 {
-		log.Println("Не найдено ни одной анкеты с таким айди:", id.String())
-		return errs.InternalServerError
+	if !matched.(bool) {
+		return r.missOrConflict(ctx, id)
 	}
 
 	return nil
 }
 
+// AddLike атомарно добавляет лайк; false - лайк уже был
+func (r *MongoAnketaRepo) AddLike(ctx context.Context, id uuid.UUID, likerId uuid.UUID) (bool, error) {
+
+	filter := bson.M{"id": id.String(), "liked_by": bson.M{"$ne": likerId.String()}}
+	// лайки не входят в версию: иначе чужой лайк отклонял бы правку анкеты владельцем
+	update := bson.M{"$push": bson.M{"liked_by": likerId.String()}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println("Не удалось добавить лайк", err)
+		return false, err
+	}
+
+	if result.MatchedCount == 0 {
+		if err := r.missOrConflict(ctx, id); errors.Is(err, errs.ErrAnketaNotFound) {
+			return false, err
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// missOrConflict объясняет, почему условное обновление ничего не нашло
+func (r *MongoAnketaRepo) missOrConflict(ctx context.Context, id uuid.UUID) error {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"id": id.String()})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		log.Println("Не найдено ни одной анкеты с таким айди:", id.String())
+		return errs.ErrAnketaNotFound
+	}
+	return errs.ErrVersionConflict
+}
+
+// Delete удаляет анкету вместе с ее историей изменений
 func (r *MongoAnketaRepo) Delete(ctx context.Context, id uuid.UUID) error {
 
+	if err := r.history.DeleteByEntity(ctx, id.String()); err != nil {
+		log.Println("Не удалось удалить историю анкеты", err)
+		return err
+	}
+
 	filter := bson.M{"id": id.String()}
 
 	result, err := r.collection.DeleteOne(ctx, filter)
@@ -110,7 +194,34 @@ func (r *MongoAnketaRepo) Delete(ctx context.Context, id uuid.UUID) error {
 	}
 
 	if result.DeletedCount == 0 {
-		return errs.InternalServerError
+		return errs.ErrAnketaNotFound
+	}
+
+	return nil
+}
+
+func (r *MongoAnketaRepo) SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	return r.setVisibilityFlag(ctx, id, "hidden", hidden)
+}
+
+func (r *MongoAnketaRepo) SetAccountHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	return r.setVisibilityFlag(ctx, id, "account_hidden", hidden)
+}
+
+// setVisibilityFlag меняет один из флагов, по которым анкета исключается из подбора
+func (r *MongoAnketaRepo) setVisibilityFlag(ctx context.Context, id uuid.UUID, field string, hidden bool) error {
+
+	filter := bson.M{"id": id.String()}
+	update := bson.M{"$set": bson.M{field: hidden}, "$inc": bson.M{"version": 1}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println("Не удалось изменить видимость анкеты", err)
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errs.ErrAnketaNotFound
 	}
 
 	return nil
@@ -123,6 +234,9 @@ func (r *MongoAnketaRepo) FindByID(ctx context.Context, id uuid.UUID) (domain.An
 	var anketaDTO anketaDTO
 
 	err := r.collection.FindOne(ctx, filter).Decode(&anketaDTO)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return domain.Anketa{}, errs.ErrAnketaNotFound
+	}
 	if err != nil {
 		log.Println("Не удалось найти пользователя по айди", id.String(), err)
 		return domain.Anketa{}, errs.InternalServerError
@@ -136,6 +250,49 @@ func (r *MongoAnketaRepo) FindByID(ctx context.Context, id uuid.UUID) (domain.An
 	return anketa, nil
 }
 
+// RemoveLikesBy убирает лайки анкеты likerId из всех анкет. Как и AddLike, версию не меняет
+func (r *MongoAnketaRepo) RemoveLikesBy(ctx context.Context, likerId uuid.UUID) error {
+
+	filter := bson.M{"liked_by": likerId.String()}
+	update := bson.M{"$pull": bson.M{"liked_by": likerId.String()}}
+
+	if _, err := r.collection.UpdateMany(ctx, filter, update); err != nil {
+		log.Println("Не удалось удалить лайки анкеты", likerId.String(), err)
+		return err
+	}
+	return nil
+}
+
+// FindLikedBy возвращает анкеты, которые лайкнула анкета id
+func (r *MongoAnketaRepo) FindLikedBy(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+
+	filter := bson.M{"liked_by": id.String()}
+	opts := options.Find().SetProjection(bson.M{"id": 1})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println("Не удалось получить лайки анкеты", id.String(), err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dtos []anketaDTO
+	if err := cursor.All(ctx, &dtos); err != nil {
+		return nil, err
+	}
+
+	liked := make([]uuid.UUID, 0, len(dtos))
+	for _, dto := range dtos {
+		anketaId, err := uuid.Parse(dto.ID)
+		if err != nil {
+			continue
+		}
+		liked = append(liked, anketaId)
+	}
+
+	return liked, nil
+}
+
 func (r *MongoAnketaRepo) GetAnketas(ctx context.Context, pref domain.PreferredAnketaGender, id uuid.UUID) ([]domain.Anketa, error) {
 	log.Printf("=== GetAnketas: ищем анкету пользователя по ID: %s ===", id.String())
 	
@@ -157,7 +314,7 @@ func (r *MongoAnketaRepo) GetAnketas(ctx context.Context, pref domain.PreferredA
 
 	if pref.Value == domain.PreferredBoth {
 		log.Printf("Ищем всех (PreferredBoth)")
-		cursor, err = r.collection.Find(ctx, bson.M{})
+		cursor, err = r.collection.Find(ctx, bson.M{"hidden": bson.M{"$ne": true}, "account_hidden": bson.M{"$ne": true}})
 		if err != nil {
 			return []domain.Anketa{}, fmt.Errorf("Ошибка на стороне сервера, просим прощения, мы уже работаем над этим")
 		}
@@ -190,6 +347,8 @@ func (r *MongoAnketaRepo) GetAnketas(ctx context.Context, pref domain.PreferredA
 			bson.M{
 				"preferred_gender": userPreferredGender,
 				"gender": targetGender,
+				"hidden": bson.M{"$ne": true},
+				"account_hidden": bson.M{"$ne": true},
 			})
 		if err != nil {
 			log.Printf("Ошибка поиска в БД: %v", err)
@@ -340,6 +499,9 @@ func anketaDTOtoDomainAnketa(a anketaDTO) (domain.Anketa, error) {
 		Tags:            tagsArray,
 		Photos:          photosArray,
 		LikedBy:         likedBy,
+		Hidden:          a.Hidden,
+		AccountHidden:   a.AccountHidden,
+		Version:         a.Version,
 	}, nil
 }
 
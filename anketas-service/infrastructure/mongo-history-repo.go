@@ -0,0 +1,81 @@
+package infrastructure
+
+import (
+	"anketas-service/domain"
+	errs "anketas-service/errors"
+	"auth-kit/mongokit"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const historyCollection = "anketa_history"
+
+func newAnketaHistory(db *mongo.Database) *mongokit.History {
+	return mongokit.NewHistory(db.Collection(historyCollection), "anketa_id")
+}
+
+// historyRecords переводит записи в общий формат истории
+func historyRecords(changes []domain.ChangeRecord) []mongokit.ChangeRecord {
+	records := make([]mongokit.ChangeRecord, 0, len(changes))
+	for _, change := range changes {
+		records = append(records, mongokit.ChangeRecord{
+			EntityID:  change.AnketaID.String(),
+			Field:     change.Field,
+			OldValue:  change.OldValue,
+			NewValue:  change.NewValue,
+			Actor:     change.Actor,
+			Version:   change.Version,
+			ChangedAt: change.ChangedAt,
+		})
+	}
+	return records
+}
+
+type MongoHistoryRepo struct {
+	history *mongokit.History
+}
+
+func NewHistoryRepo(db *mongo.Client) *MongoHistoryRepo {
+	repo := &MongoHistoryRepo{newAnketaHistory(db.Database("main"))}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := repo.history.CreateIndexes(ctx); err != nil {
+		log.Println("Не удалось создать индекс истории анкет", err)
+	}
+
+	return repo
+}
+
+func (r *MongoHistoryRepo) FindByAnketa(ctx context.Context, id uuid.UUID, before string, limit int) ([]domain.ChangeRecord, error) {
+
+	found, err := r.history.Find(ctx, id.String(), before, limit)
+	if errors.Is(err, mongokit.ErrInvalidHistoryCursor) {
+		return nil, errs.ErrInvalidHistoryCursor
+	}
+	if err != nil {
+		log.Println("Не удалось получить историю анкеты", id.String(), err)
+		return nil, err
+	}
+
+	records := make([]domain.ChangeRecord, 0, len(found))
+	for _, record := range found {
+		records = append(records, domain.ChangeRecord{
+			ID:        record.ID,
+			AnketaID:  id,
+			Field:     record.Field,
+			OldValue:  record.OldValue,
+			NewValue:  record.NewValue,
+			Actor:     record.Actor,
+			Version:   record.Version,
+			ChangedAt: record.ChangedAt,
+		})
+	}
+	return records, nil
+}
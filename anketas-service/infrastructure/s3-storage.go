@@ -10,7 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	// "github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	// "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 )
 
@@ -99,6 +99,74 @@ func (s *S3Storage) DeletePhoto(ctx context.Context, key string) error {
 	return nil
 }
 
+// Удаляет все фотографии пользователя, загруженные по presigned URL
+func (s *S3Storage) DeleteUserPhotos(ctx context.Context, userID string) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(fmt.Sprintf("photos/%s/", userID)),
+	})
+
+	deleted := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("не удалось получить список фотографий: %v", err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+
+		_, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucketName),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("не удалось удалить фотографии: %v", err)
+		}
+		deleted += len(objects)
+	}
+
+	log.Printf("Удалено фотографий пользователя %s: %d", userID, deleted)
+	return nil
+}
+
+// Ссылка на фотографию пользователя в bucket
+type UserPhoto struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+// Возвращает все фотографии пользователя со ссылками для скачивания
+func (s *S3Storage) ListUserPhotos(ctx context.Context, userID string) ([]UserPhoto, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(fmt.Sprintf("photos/%s/", userID)),
+	})
+
+	photos := []UserPhoto{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось получить список фотографий: %v", err)
+		}
+
+		for _, object := range page.Contents {
+			url, err := s.GetPhotoURL(ctx, aws.ToString(object.Key))
+			if err != nil {
+				return nil, err
+			}
+			photos = append(photos, UserPhoto{Key: aws.ToString(object.Key), URL: url})
+		}
+	}
+
+	return photos, nil
+}
+
 // Проверяет существование bucket
 func (s *S3Storage) CheckBucketExists(ctx context.Context) error {
 	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
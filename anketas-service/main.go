@@ -5,6 +5,8 @@ import (
 	"anketas-service/infrastructure"
 	"anketas-service/service"
 	"anketas-service/transport"
+	"auth-kit"
+	"auth-kit/mongokit"
 	"context"
 	"log"
 	"os"
@@ -29,8 +31,14 @@ func main() {
 
 	log.Println("Подключение к БД прошло успешно")
 
+	// изменения анкеты пишутся вместе с историей в транзакции, без нее сервис не запускается
+	if err := mongokit.RequireTransactions(db); err != nil {
+		log.Println("База данных не поддерживает транзакции |", err)
+		return
+	}
+
 	repo := infrastructure.NewAnketaRepo(db)
-	service := service.NewAnketaService(repo)
+	service := service.NewAnketaService(repo, infrastructure.NewHistoryRepo(db))
 	
 	s3Storage, err := infrastructure.NewS3Storage()
 	if err != nil {
@@ -46,9 +54,25 @@ func main() {
 	}
 	log.Println("S3 Storage инициализирован, bucket доступен")
 	
-	handler := transport.NewAnketaHandler(service, s3Storage)
+	auth := authkit.NewAuthenticator(authkit.ConfigFromEnv())
+	authClient, err := authkit.InternalClientFromEnv("anketas-service")
+	if err != nil {
+		log.Println("Не удалось настроить подпись запросов к auth-service |", err)
+		return
+	}
+	// ключи сервисов, которым разрешены служебные запросы (user-service при удалении аккаунта)
+	internalKeys, err := authkit.InternalKeysFromEnv()
+	if err != nil {
+		log.Println("Некорректный INTERNAL_KEYS |", err)
+		return
+	}
+	handler := transport.NewAnketaHandler(service, s3Storage, auth, authClient, internalKeys)
 
 	r := gin.Default()
+	if err := r.SetTrustedProxies(authkit.TrustedProxiesFromEnv()); err != nil {
+		log.Println("Некорректное значение TRUSTED_PROXIES |", err)
+		return
+	}
 
 	handler.RegisterRoutes(r)
 
@@ -2,6 +2,7 @@ package service
 
 import (
 	"anketas-service/domain"
+	errs "anketas-service/errors"
 	"anketas-service/valueObjects"
 	"context"
 	"errors"
@@ -12,11 +13,12 @@ import (
 )
 
 type AnketaService struct {
-	repo domain.AnketaRepository
+	repo    domain.AnketaRepository
+	history domain.HistoryRepository
 }
 
-func NewAnketaService(repo domain.AnketaRepository) AnketaService {
-	return AnketaService{repo}
+func NewAnketaService(repo domain.AnketaRepository, history domain.HistoryRepository) AnketaService {
+	return AnketaService{repo, history}
 }
 
 var (
@@ -39,7 +41,6 @@ func (s AnketaService) Create(
 	description string,
 	tags []string,
 	photos []string,
-	likedBy []string,
 ) (uuid.UUID, error) {
 
 	log.Println("Сервис начал создание анкеты")
@@ -82,16 +83,6 @@ func (s AnketaService) Create(
 		return uuid.Nil, fmt.Errorf("Неверный возраст. %w", err)
 	}
 
-	var likedByUUID []uuid.UUID
-	for _, id := range likedBy {
-		tag, err := uuid.Parse(id)
-		if err != nil {
-			log.Println("Ошибка с uuid", err)
-			return uuid.Nil, err
-		}
-		likedByUUID = append(likedByUUID, tag)
-	}
-
 	anketa := domain.Anketa{
 		ID:              uuid.New(),
 		Username:        usernameVO,
@@ -101,7 +92,6 @@ func (s AnketaService) Create(
 		Description:     description,
 		Tags:            validatedTags,
 		Photos:          validatedPhotos,
-		LikedBy:         likedByUUID,
 	}
 
 	log.Println("Сервисный слой создал анкету успешно")
@@ -128,7 +118,46 @@ func (s AnketaService) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
-func (s AnketaService) Update(ctx context.Context, updateData map[string]interface{}) error {
+func (s AnketaService) SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	if err := s.repo.SetHidden(ctx, id, hidden); err != nil {
+		return fmt.Errorf("ошибка при изменении видимости анкеты: %w", err)
+	}
+	return nil
+}
+
+// SetAccountHidden скрывает анкету на время деактивации аккаунта, не затрагивая скрытие модератором
+func (s AnketaService) SetAccountHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	if err := s.repo.SetAccountHidden(ctx, id, hidden); err != nil {
+		return fmt.Errorf("ошибка при изменении видимости анкеты: %w", err)
+	}
+	return nil
+}
+
+func (s AnketaService) GetLikedBy(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	liked, err := s.repo.FindLikedBy(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении лайков анкеты: %w", err)
+	}
+	return liked, nil
+}
+
+func (s AnketaService) RemoveLikesBy(ctx context.Context, likerId uuid.UUID) error {
+	if err := s.repo.RemoveLikesBy(ctx, likerId); err != nil {
+		return fmt.Errorf("ошибка при удалении лайков анкеты: %w", err)
+	}
+	return nil
+}
+
+func (s AnketaService) AddLike(ctx context.Context, id uuid.UUID, likerId uuid.UUID) (bool, error) {
+	added, err := s.repo.AddLike(ctx, id, likerId)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при добавлении лайка: %w", err)
+	}
+	return added, nil
+}
+
+// Update меняет анкету и записывает в историю, что изменил actor
+func (s AnketaService) Update(ctx context.Context, updateData map[string]interface{}, expectedVersion int64, actor string) error {
 
 	log.Println("Сервис начал обновление анкеты")
 
@@ -159,7 +188,16 @@ func (s AnketaService) Update(ctx context.Context, updateData map[string]interfa
 
 	log.Println("Данные для обновления верны")
 
-	if err := s.repo.Update(ctx, id, updateData); err != nil {
+	old, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if old.Version != expectedVersion {
+		return errs.ErrVersionConflict
+	}
+
+	changes := domain.NewChangeRecords(old, updateData, actor, expectedVersion+1)
+	if err := s.repo.Update(ctx, id, updateData, expectedVersion, changes); err != nil {
 		return err
 	}
 
@@ -168,6 +206,10 @@ func (s AnketaService) Update(ctx context.Context, updateData map[string]interfa
 	return nil
 }
 
+func (s AnketaService) GetHistory(ctx context.Context, id uuid.UUID, before string, limit int) ([]domain.ChangeRecord, error) {
+	return s.history.FindByAnketa(ctx, id, before, limit)
+}
+
 func (s AnketaService) validateUpdateData(updateData map[string]interface{}) error {
 	for key, value := range updateData {
 		switch key {
@@ -234,18 +276,6 @@ func (s AnketaService) validateUpdateData(updateData map[string]interface{}) err
 				return fmt.Errorf("описание должно быть строкой")
 			}
 
-		case "liked_by":
-			likedBySlice, ok := value.([]string)
-			if !ok {
-				return fmt.Errorf("liked_by должен быть массивом строк")
-			}
-			// Валидируем, что все элементы являются валидными UUID
-			for _, userID := range likedBySlice {
-				if _, err := uuid.Parse(userID); err != nil {
-					return fmt.Errorf("неверный UUID пользователя '%s': %w", userID, err)
-				}
-			}
-
 		default:
 			return fmt.Errorf("неизвестное поле '%s' для обновления", key)
 		}
@@ -2,10 +2,12 @@ package transport
 
 import (
 	"anketas-service/domain"
+	"auth-kit"
 	"anketas-service/infrastructure"
 	errs "anketas-service/errors"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -18,10 +20,13 @@ import (
 type AnketaHandler struct {
 	service domain.AnketaService
 	s3Storage *infrastructure.S3Storage
+	auth *authkit.Authenticator
+	authClient *authkit.InternalClient
+	internalKeys map[string][]byte
 }
 
-func NewAnketaHandler(service domain.AnketaService, s3Storage *infrastructure.S3Storage) AnketaHandler {
-	return AnketaHandler{service: service, s3Storage: s3Storage}
+func NewAnketaHandler(service domain.AnketaService, s3Storage *infrastructure.S3Storage, auth *authkit.Authenticator, authClient *authkit.InternalClient, internalKeys map[string][]byte) AnketaHandler {
+	return AnketaHandler{service: service, s3Storage: s3Storage, auth: auth, authClient: authClient, internalKeys: internalKeys}
 }
 
 type CreateAnketaRequest struct {
@@ -32,7 +37,6 @@ type CreateAnketaRequest struct {
 	Description     string   `json:"description" binding:"required"`
 	Tags            []string `json:"tags" binding:"required"`
 	Photos          []string `json:"photos" binding:"required"`
-	LikedBy         []string `json:"liked_by"`
 	CredType        string   `json:"cred_type"`
 	Identifier      string   `json:"identifier"`
 }
@@ -45,7 +49,6 @@ type UpdateAnketaRequest struct {
 	Description     string   `json:"description,omitempty"`
 	Tags            []string `json:"tags,omitempty"`
 	Photos          []string `json:"photos,omitempty"`
-	LikedBy         []string `json:"liked_by,omitempty"`
 	Action          string   `json:"action,omitempty"`
 	CurrentUserAnketaId string `json:"current_user_anketa_id,omitempty"`
 }
@@ -60,6 +63,18 @@ func (h AnketaHandler) CreateAnketa(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
+
+	// привязывать анкету можно только к своему аккаунту
+	if req.CredType != "" && req.Identifier != "" {
+		claims, _ := authkit.ClaimsFromContext(c)
+		userId, err := h.getUserIdFromAuthService(ctx, req.CredType, req.Identifier)
+		if err != nil || userId != claims.UserID() {
+			log.Printf("Учетные данные %s не принадлежат пользователю из токена", req.CredType)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+			return
+		}
+	}
+
 	anketaID, err := h.service.Create(
 		ctx,
 		req.Username,
@@ -69,7 +84,6 @@ func (h AnketaHandler) CreateAnketa(c *gin.Context) {
 		req.Description,
 		req.Tags,
 		req.Photos,
-		req.LikedBy,
 	)
 
 	if err != nil {
@@ -83,14 +97,14 @@ func (h AnketaHandler) CreateAnketa(c *gin.Context) {
 			req.CredType, req.Identifier, anketaID.String())
 		
 		// Сначала сохраняем по переданному типу
-		saveAnketaIdToAuthService(req.CredType, req.Identifier, anketaID.String())
+		h.saveAnketaIdToAuthService(ctx, req.CredType, req.Identifier, anketaID.String())
 		
 		// Затем получаем все учетные данные и сохраняем по всем типам
-		allCreds, err := getAllUserCredsFromAuthService(req.CredType, req.Identifier)
+		allCreds, err := h.getAllUserCredsFromAuthService(ctx, req.CredType, req.Identifier)
 		if err == nil && allCreds != nil {
 			log.Printf("Получены все учетные данные: login=%s, email=%s, phone=%s", 
 				allCreds["login"], allCreds["email"], allCreds["phone"])
-			saveAnketaIdToAllInAuthService(allCreds["login"], allCreds["email"], allCreds["phone"], anketaID.String())
+			h.saveAnketaIdToAllInAuthService(ctx, allCreds["login"], allCreds["email"], allCreds["phone"], anketaID.String())
 		} else {
 			log.Printf("Не удалось получить все учетные данные, сохранено только по %s", req.CredType)
 		}
@@ -125,6 +139,7 @@ func (h AnketaHandler) GetAnketaByID(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", authkit.FormatETag(anketa.Version))
 	c.JSON(http.StatusOK, anketa)
 }
 
@@ -185,6 +200,17 @@ func (h AnketaHandler) UpdateAnketa(c *gin.Context) {
 
 	log.Printf("Получен запрос: Action=%s, CurrentUserAnketaId=%s", req.Action, req.CurrentUserAnketaId)
 
+	// Лайк ставится на чужую анкету от имени своей, остальные изменения - только в своей
+	claims, _ := authkit.ClaimsFromContext(c)
+	ownAnketaId := c.Param("id")
+	if req.Action == "like" {
+		ownAnketaId = req.CurrentUserAnketaId
+	}
+	if claims == nil || claims.AnketaID == "" || claims.AnketaID != ownAnketaId {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		return
+	}
+
 	// Обработка лайка
 	if req.Action == "like" && req.CurrentUserAnketaId != "" {
 		log.Printf("=== ОБРАБОТКА ЛАЙКА ===")
@@ -196,55 +222,42 @@ func (h AnketaHandler) UpdateAnketa(c *gin.Context) {
 			return
 		}
 		
-		// Получаем текущую анкету, чтобы проверить liked_by
-		ctx := c.Request.Context()
-		anketa, err := h.service.GetAnketaByID(ctx, targetAnketaId)
+		likerId, err := uuid.Parse(req.CurrentUserAnketaId)
 		if err != nil {
-			log.Printf("Ошибка при получении анкеты: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Анкета не найдена"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат ID анкеты"})
 			return
 		}
-		
-		// Проверяем, не лайкал ли уже пользователь
-		alreadyLiked := false
-		for _, likedBy := range anketa.LikedBy {
-			if likedBy.String() == req.CurrentUserAnketaId {
-				alreadyLiked = true
-				break
-			}
-		}
-		
-		if alreadyLiked {
-			log.Printf("Пользователь уже лайкнул эту анкету")
-			c.JSON(http.StatusOK, gin.H{"message": "Лайк уже был поставлен"})
+
+		// Лайк добавляется атомарно и не зависит от версии: одновременные лайки не теряются
+		ctx := c.Request.Context()
+		added, err := h.service.AddLike(ctx, targetAnketaId, likerId)
+		if errors.Is(err, errs.ErrAnketaNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Анкета не найдена"})
 			return
 		}
-		
-		// Добавляем лайк через Update
-		updateData := map[string]interface{}{
-			"id": idStr,
-		}
-		
-		// Получаем текущий список liked_by и добавляем новый ID
-		currentLikedBy := make([]string, 0, len(anketa.LikedBy)+1)
-		for _, id := range anketa.LikedBy {
-			currentLikedBy = append(currentLikedBy, id.String())
-		}
-		currentLikedBy = append(currentLikedBy, req.CurrentUserAnketaId)
-		updateData["liked_by"] = currentLikedBy
-		
-		err = h.service.Update(ctx, updateData)
 		if err != nil {
 			log.Printf("Ошибка при добавлении лайка: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+
+		if !added {
+			log.Printf("Пользователь уже лайкнул эту анкету")
+			c.JSON(http.StatusOK, gin.H{"message": "Лайк уже был поставлен"})
+			return
+		}
 		
 		log.Printf("Лайк успешно добавлен")
 		c.JSON(http.StatusOK, gin.H{"message": "Лайк успешно добавлен"})
 		return
 	}
 
+	// остальные изменения применяются только к версии, которую видел клиент
+	expectedVersion, ok := authkit.RequireVersion(c, "анкеты")
+	if !ok {
+		return
+	}
+
 	updateData := make(map[string]interface{})
 
 	if req.Username != "" {
@@ -265,20 +278,23 @@ func (h AnketaHandler) UpdateAnketa(c *gin.Context) {
 	if req.Photos != nil {
 		updateData["photos"] = req.Photos
 	}
-	if req.LikedBy != nil {
-		updateData["liked_by"] = req.LikedBy
-	}
 
 	updateData["id"] = c.Param("id")
 
 	ctx := c.Request.Context()
-	err := h.service.Update(ctx, updateData)
+	err := h.service.Update(ctx, updateData, expectedVersion, claims.UserID())
+	if errors.Is(err, errs.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Не удалось обновить анкету | %s", err.Error())})
 		return
 	}
 
+	c.Header("ETag", authkit.FormatETag(expectedVersion+1))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Анкета успешно обновлена",
 	})
@@ -307,6 +323,172 @@ func (h AnketaHandler) DeleteAnketa(c *gin.Context) {
 	})
 }
 
+// SetAnketaHidden скрывает анкету из подбора или возвращает ее обратно
+func (h AnketaHandler) SetAnketaHidden(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Неверный формат ID",
+		})
+		return
+	}
+
+	var req struct {
+		Hidden *bool `json:"hidden" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	err = h.service.SetHidden(c.Request.Context(), id, *req.Hidden)
+	if errors.Is(err, errs.ErrAnketaNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Анкета не найдена"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка изменения видимости анкеты: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+		return
+	}
+
+	claims, _ := authkit.ClaimsFromContext(c)
+	log.Printf("Администратор %s изменил видимость анкеты %s: hidden=%t", claims.UserID(), id.String(), *req.Hidden)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Видимость анкеты изменена",
+		"hidden":  *req.Hidden,
+	})
+}
+
+// SetUserAnketaHidden скрывает анкету при деактивации аккаунта и показывает при восстановлении; вызывает user-service.
+// Анкета, скрытая модератором, после восстановления аккаунта остается скрытой
+func (h AnketaHandler) SetUserAnketaHidden(c *gin.Context) {
+	var req struct {
+		AnketaId string `json:"anketa_id" binding:"required"`
+		Hidden   bool   `json:"hidden"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	id, err := uuid.Parse(req.AnketaId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат ID"})
+		return
+	}
+
+	err = h.service.SetAccountHidden(c.Request.Context(), id, req.Hidden)
+	if errors.Is(err, errs.ErrAnketaNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Анкета не найдена"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка изменения видимости анкеты: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hidden": req.Hidden})
+}
+
+// PurgeUser удаляет анкету, ее лайки в чужих анкетах и все фотографии удаляемого пользователя.
+// Повторный вызов безопасен: уже удаленная анкета не считается ошибкой
+func (h AnketaHandler) PurgeUser(c *gin.Context) {
+	var req struct {
+		UserId   string `json:"user_id" binding:"required"`
+		AnketaId string `json:"anketa_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if req.AnketaId != "" {
+		id, err := uuid.Parse(req.AnketaId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат ID"})
+			return
+		}
+
+		// лайки снимаются до удаления анкеты, чтобы повтор после ошибки их не пропустил
+		if err := h.service.RemoveLikesBy(ctx, id); err != nil {
+			log.Printf("Ошибка удаления лайков анкеты %s: %v", req.AnketaId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+			return
+		}
+
+		err = h.service.Delete(ctx, id)
+		if err != nil && !errors.Is(err, errs.ErrAnketaNotFound) {
+			log.Printf("Ошибка удаления анкеты %s: %v", req.AnketaId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+			return
+		}
+	}
+
+	if err := h.s3Storage.DeleteUserPhotos(ctx, req.UserId); err != nil {
+		log.Printf("Ошибка удаления фотографий пользователя %s: %v", req.UserId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Данные пользователя удалены"})
+}
+
+// ExportUser отдает анкету пользователя с лайками и ссылками на его фотографии для выгрузки персональных данных
+func (h AnketaHandler) ExportUser(c *gin.Context) {
+	var req struct {
+		UserId   string `json:"user_id" binding:"required"`
+		AnketaId string `json:"anketa_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	response := gin.H{"anketa": nil, "liked": []uuid.UUID{}}
+
+	if req.AnketaId != "" {
+		id, err := uuid.Parse(req.AnketaId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат ID"})
+			return
+		}
+
+		anketa, err := h.service.GetAnketaByID(ctx, id)
+		if err != nil && !errors.Is(err, errs.ErrAnketaNotFound) {
+			log.Printf("Ошибка выгрузки анкеты %s: %v", req.AnketaId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+			return
+		}
+		if err == nil {
+			response["anketa"] = anketa
+		}
+
+		liked, err := h.service.GetLikedBy(ctx, id)
+		if err != nil {
+			log.Printf("Ошибка выгрузки лайков анкеты %s: %v", req.AnketaId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+			return
+		}
+		response["liked"] = liked
+	}
+
+	photos, err := h.s3Storage.ListUserPhotos(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Ошибка выгрузки фотографий пользователя %s: %v", req.UserId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+		return
+	}
+	response["photos"] = photos
+
+	c.JSON(http.StatusOK, response)
+}
+
 func (h AnketaHandler) GetTags(c *gin.Context) {
 
 	tags := []string{
@@ -336,6 +518,12 @@ func (h AnketaHandler) GetUploadURL(c *gin.Context) {
 		return
 	}
 
+	claims, _ := authkit.ClaimsFromContext(c)
+	if claims == nil || claims.Subject != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		return
+	}
+
 	uploadURL, err := h.s3Storage.GenerateUploadURL(c.Request.Context(), userID)
 	if err != nil {
 		log.Printf("Ошибка создания presigned URL: %v", err)
@@ -349,20 +537,14 @@ func (h AnketaHandler) GetUploadURL(c *gin.Context) {
 	})
 }
 
-func saveAnketaIdToAuthService(credType, identifier, anketaId string) {
-	url := "http://127.0.0.1:8001/saveAnketaId"
+func (h AnketaHandler) saveAnketaIdToAuthService(ctx context.Context, credType, identifier, anketaId string) {
 	data := map[string]string{
 		"cred_type": credType,
 		"identifier": identifier,
 		"anketa_id": anketaId,
 	}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Ошибка маршалинга данных для сохранения anketa_id: %v", err)
-		return
-	}
 	
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := h.authClient.PostJSON(ctx, "/saveAnketaId", data)
 	if err != nil {
 		log.Printf("Ошибка сохранения anketa_id в auth-service: %v", err)
 		return
@@ -376,18 +558,39 @@ func saveAnketaIdToAuthService(credType, identifier, anketaId string) {
 	}
 }
 
-func getAllUserCredsFromAuthService(credType, identifier string) (map[string]string, error) {
-	url := "http://127.0.0.1:8001/getAllUserCreds"
+func (h AnketaHandler) getUserIdFromAuthService(ctx context.Context, credType, identifier string) (string, error) {
 	data := map[string]string{
 		"cred_type": credType,
 		"identifier": identifier,
 	}
-	jsonData, err := json.Marshal(data)
+
+	resp, err := h.authClient.PostJSON(ctx, "/getUserId", data)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("статус код %d", resp.StatusCode)
+	}
+
+	var result struct {
+		UserId string `json:"user_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.UserId, nil
+}
+
+func (h AnketaHandler) getAllUserCredsFromAuthService(ctx context.Context, credType, identifier string) (map[string]string, error) {
+	data := map[string]string{
+		"cred_type": credType,
+		"identifier": identifier,
 	}
 	
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := h.authClient.PostJSON(ctx, "/getAllUserCreds", data)
 	if err != nil {
 		return nil, err
 	}
@@ -405,21 +608,15 @@ func getAllUserCredsFromAuthService(credType, identifier string) (map[string]str
 	return result, nil
 }
 
-func saveAnketaIdToAllInAuthService(login, email, phone, anketaId string) {
-	url := "http://127.0.0.1:8001/saveAnketaIdToAll"
+func (h AnketaHandler) saveAnketaIdToAllInAuthService(ctx context.Context, login, email, phone, anketaId string) {
 	data := map[string]string{
 		"login": login,
 		"email": email,
 		"phone": phone,
 		"anketa_id": anketaId,
 	}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Ошибка маршалинга данных для сохранения anketa_id по всем типам: %v", err)
-		return
-	}
 	
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := h.authClient.PostJSON(ctx, "/saveAnketaIdToAll", data)
 	if err != nil {
 		log.Printf("Ошибка сохранения anketa_id по всем типам в auth-service: %v", err)
 		return
@@ -434,12 +631,58 @@ func saveAnketaIdToAllInAuthService(login, email, phone, anketaId string) {
 	}
 }
 
+// GetAnketaHistory - для модерации: кто и когда менял анкету, от новых к старым
+func (h AnketaHandler) GetAnketaHistory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат ID"})
+		return
+	}
+
+	limit, ok := authkit.HistoryLimit(c)
+	if !ok {
+		return
+	}
+
+	records, err := h.service.GetHistory(c.Request.Context(), id, c.Query("before"), limit)
+	if errors.Is(err, errs.ErrInvalidHistoryCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка чтения истории анкеты: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errs.InternalServerError.Error()})
+		return
+	}
+
+	changes := make([]authkit.HistoryEntry, 0, len(records))
+	for _, record := range records {
+		changes = append(changes, authkit.HistoryEntry{
+			ID:        record.ID,
+			Field:     record.Field,
+			OldValue:  record.OldValue,
+			NewValue:  record.NewValue,
+			Actor:     record.Actor,
+			Version:   record.Version,
+			ChangedAt: record.ChangedAt,
+		})
+	}
+	authkit.RespondHistory(c, changes, limit)
+}
+
 func (h AnketaHandler) RegisterRoutes(r *gin.Engine) {
-	r.POST("/create", h.CreateAnketa)
+	r.POST("/create", h.auth.Middleware(), h.CreateAnketa)
 	r.GET("/anketa/:id", h.GetAnketaByID)
-	r.PUT("/anketa/:id", h.UpdateAnketa)
-	r.DELETE("/anketa/:id", h.DeleteAnketa)
+	r.PUT("/anketa/:id", h.auth.Middleware(), h.UpdateAnketa)
+	r.GET("/anketa/:id/history", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin, authkit.RoleModerator), h.GetAnketaHistory)
+	r.DELETE("/anketa/:id", h.auth.Middleware(), authkit.RequireAnketaOwner("id"), h.DeleteAnketa)
 	r.GET("/anketas/match", h.GetAnketas)
 	r.GET("/tags", h.GetTags)
-	r.GET("/upload-url", h.GetUploadURL)
+	r.GET("/upload-url", h.auth.Middleware(), h.GetUploadURL)
+	r.PUT("/admin/anketa/:id/hidden", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.SetAnketaHidden)
+
+	internal := r.Group("/internal", authkit.RequireInternal(h.internalKeys))
+	internal.POST("/anketa/hidden", h.SetUserAnketaHidden)
+	internal.POST("/purge", h.PurgeUser)
+	internal.POST("/export", h.ExportUser)
 }
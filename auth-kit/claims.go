@@ -0,0 +1,31 @@
+package authkit
+
+import (
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Роли аккаунта; роль user есть у всех
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Claims - содержимое access токена, выпущенного auth-service
+type Claims struct {
+	AnketaID  string   `json:"anketa_id,omitempty"`
+	Roles     []string `json:"roles"`
+	Version   int64    `json:"ver"`
+	SessionID string   `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
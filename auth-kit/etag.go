@@ -0,0 +1,33 @@
+package authkit
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Версия сущности отдается как ETag, а изменения принимаются только с If-Match этой версии
+
+// FormatETag отдает версию как ETag
+func FormatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// RequireVersion читает ожидаемую версию из If-Match; без заголовка изменение не принимается.
+// entity - чей ETag нужен, в родительном падеже: "пользователя", "анкеты"
+func RequireVersion(c *gin.Context, entity string) (int64, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Нужен заголовок If-Match с ETag " + entity})
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Некорректный If-Match"})
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,42 @@
+package authkit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryPageMax ограничивает размер страницы истории изменений
+const HistoryPageMax = 200
+
+// HistoryEntry - запись истории изменений в ответе
+type HistoryEntry struct {
+	ID        string    `json:"id"`
+	Field     string    `json:"field"`
+	OldValue  any       `json:"old_value"`
+	NewValue  any       `json:"new_value"`
+	Actor     string    `json:"actor"`
+	Version   int64     `json:"version"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// HistoryLimit читает размер страницы из limit (50 по умолчанию, не больше HistoryPageMax)
+func HistoryLimit(c *gin.Context) (int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный limit"})
+		return 0, false
+	}
+	return min(limit, HistoryPageMax), true
+}
+
+// RespondHistory отдает страницу истории; next_before есть, только если страница заполнена целиком
+func RespondHistory(c *gin.Context, entries []HistoryEntry, limit int) {
+	response := gin.H{"changes": entries}
+	if len(entries) == limit {
+		response["next_before"] = entries[len(entries)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
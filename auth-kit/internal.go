@@ -0,0 +1,213 @@
+package authkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Служебные запросы между сервисами подписываются HMAC-SHA256 ключом сервиса-отправителя.
+// Подпись покрывает метод, путь, время, nonce и хеш тела, так что запрос нельзя
+// ни изменить, ни переиспользовать после MaxClockSkew; повторы внутри окна
+// отсекает получатель по nonce.
+
+const (
+	HeaderService   = "X-Service-Name"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+
+	// HeaderIdempotencyKey - ключ, по которому получатель узнает повтор уже выполненного запроса
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	MaxClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrSignatureMissing = errors.New("запрос не подписан")
+	ErrUnknownService   = errors.New("неизвестный сервис")
+	ErrSignatureExpired = errors.New("подпись просрочена")
+	ErrSignatureInvalid = errors.New("неверная подпись")
+)
+
+func canonicalRequest(method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{method, path, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")
+}
+
+func sign(key []byte, canonical string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest добавляет к запросу заголовки подписи; body - уже записанное в запрос тело
+func SignRequest(req *http.Request, service string, key []byte, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+
+	req.Header.Set(HeaderService, service)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonceHex)
+	req.Header.Set(HeaderSignature, sign(key, canonicalRequest(req.Method, req.URL.Path, timestamp, nonceHex, body)))
+	return nil
+}
+
+// SignedRequest - проверенные заголовки подписи
+type SignedRequest struct {
+	Service   string
+	Nonce     string
+	Timestamp time.Time
+}
+
+// VerifyRequest проверяет подпись по ключам сервисов. Проверка уникальности nonce
+// остается за вызывающим, так как требует общего хранилища
+func VerifyRequest(req *http.Request, body []byte, keys map[string][]byte) (SignedRequest, error) {
+	service := req.Header.Get(HeaderService)
+	timestamp := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	signature := req.Header.Get(HeaderSignature)
+	if service == "" || timestamp == "" || nonce == "" || signature == "" {
+		return SignedRequest{}, ErrSignatureMissing
+	}
+
+	key, ok := keys[service]
+	if !ok {
+		return SignedRequest{}, ErrUnknownService
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return SignedRequest{}, ErrSignatureInvalid
+	}
+	signedAt := time.Unix(unix, 0)
+	if skew := time.Since(signedAt); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return SignedRequest{}, ErrSignatureExpired
+	}
+
+	expected := sign(key, canonicalRequest(req.Method, req.URL.Path, timestamp, nonce, body))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return SignedRequest{}, ErrSignatureInvalid
+	}
+
+	return SignedRequest{Service: service, Nonce: nonce, Timestamp: signedAt}, nil
+}
+
+// ParseServiceKeys разбирает список вида "user-service:<base64>,anketas-service:<base64>"
+func ParseServiceKeys(value string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		service, encoded, found := strings.Cut(item, ":")
+		if !found || service == "" {
+			return nil, errors.New("ожидается формат сервис:ключ")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) < 32 {
+			return nil, errors.New("ключ сервиса " + service + " должен быть не короче 32 байт в base64")
+		}
+		keys[service] = key
+	}
+	return keys, nil
+}
+
+// InternalKeysFromEnv читает ключи сервисов-отправителей из INTERNAL_KEYS
+func InternalKeysFromEnv() (map[string][]byte, error) {
+	return ParseServiceKeys(os.Getenv("INTERNAL_KEYS"))
+}
+
+// InternalClient отправляет подписанные запросы во внутренние ручки auth-service
+type InternalClient struct {
+	baseURL string
+	service string
+	key     []byte
+	client  *http.Client
+}
+
+func NewInternalClient(baseURL, service string, key []byte) *InternalClient {
+	return &InternalClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		service: service,
+		key:     key,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// InternalClientFromEnv собирает клиент из AUTH_SERVICE_URL, SERVICE_NAME и INTERNAL_KEY (base64)
+func InternalClientFromEnv(defaultService string) (*InternalClient, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("INTERNAL_KEY"))
+	if err != nil || len(key) < 32 {
+		return nil, errors.New("INTERNAL_KEY должен быть не короче 32 байт в base64")
+	}
+
+	return NewInternalClient(
+		getEnv("AUTH_SERVICE_URL", "http://127.0.0.1:8001"),
+		getEnv("SERVICE_NAME", defaultService),
+		key,
+	), nil
+}
+
+// For возвращает клиент с тем же ключом для другого сервиса, принимающего подписанные запросы
+func (c *InternalClient) For(baseURL string) *InternalClient {
+	return NewInternalClient(baseURL, c.service, c.key)
+}
+
+// PostJSON отправляет payload как JSON на путь path; тело ответа закрывает вызывающий
+func (c *InternalClient) PostJSON(ctx context.Context, path string, payload any) (*http.Response, error) {
+	return c.PostJSONWithHeader(ctx, path, nil, payload)
+}
+
+// PostJSONIdempotent - то же, что PostJSON, но с ключом идемпотентности: повтор
+// с тем же ключом получит сохраненный ответ вместо повторного выполнения
+func (c *InternalClient) PostJSONIdempotent(ctx context.Context, path, idempotencyKey string, payload any) (*http.Response, error) {
+	header := http.Header{}
+	header.Set(HeaderIdempotencyKey, idempotencyKey)
+	return c.PostJSONWithHeader(ctx, path, header, payload)
+}
+
+// PostJSONWithHeader - то же, что PostJSON, с дополнительными заголовками
+// (например, адресом и User-Agent клиента, от имени которого выполняется запрос)
+func (c *InternalClient) PostJSONWithHeader(ctx context.Context, path string, header http.Header, payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := SignRequest(req, c.service, c.key, body); err != nil {
+		return nil, err
+	}
+
+	return c.client.Do(req)
+}
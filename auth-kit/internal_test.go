@@ -0,0 +1,178 @@
+package authkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	testServiceKey = bytes.Repeat([]byte{7}, 32)
+	testKeys       = map[string][]byte{"user-service": testServiceKey}
+)
+
+func signedTestRequest(t *testing.T, body []byte) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://auth/updateCredentials", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRequest(req, "user-service", testServiceKey, body); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// resign подписывает запрос заново с заданным временем, как сделал бы отправитель с этим ключом
+func resign(req *http.Request, key []byte, signedAt time.Time, body []byte) {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, sign(key, canonicalRequest(req.Method, req.URL.Path, timestamp, req.Header.Get(HeaderNonce), body)))
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := []byte(`{"user_id":"1","login":"alice"}`)
+
+	tests := []struct {
+		name    string
+		tamper  func(req *http.Request) []byte
+		wantErr error
+	}{
+		{
+			name:   "без изменений",
+			tamper: func(req *http.Request) []byte { return body },
+		},
+		{
+			name:    "изменено тело",
+			tamper:  func(req *http.Request) []byte { return []byte(`{"user_id":"2","login":"alice"}`) },
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "изменен метод",
+			tamper: func(req *http.Request) []byte {
+				req.Method = http.MethodPut
+				return body
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "изменен путь",
+			tamper: func(req *http.Request) []byte {
+				req.URL.Path = "/setAccountRoles"
+				return body
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "подменен nonce",
+			tamper: func(req *http.Request) []byte {
+				req.Header.Set(HeaderNonce, "00000000000000000000000000000000")
+				return body
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "сдвинуто время без переподписи",
+			tamper: func(req *http.Request) []byte {
+				req.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix()+1, 10))
+				return body
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "время не число",
+			tamper: func(req *http.Request) []byte {
+				req.Header.Set(HeaderTimestamp, "вчера")
+				return body
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "подпись чужим ключом",
+			tamper: func(req *http.Request) []byte {
+				resign(req, bytes.Repeat([]byte{8}, 32), time.Now(), body)
+				return body
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "неизвестный сервис",
+			tamper: func(req *http.Request) []byte {
+				req.Header.Set(HeaderService, "anketas-service")
+				return body
+			},
+			wantErr: ErrUnknownService,
+		},
+		{
+			name: "нет подписи",
+			tamper: func(req *http.Request) []byte {
+				req.Header.Del(HeaderSignature)
+				return body
+			},
+			wantErr: ErrSignatureMissing,
+		},
+		{
+			name: "повтор после MaxClockSkew",
+			tamper: func(req *http.Request) []byte {
+				resign(req, testServiceKey, time.Now().Add(-MaxClockSkew-time.Minute), body)
+				return body
+			},
+			wantErr: ErrSignatureExpired,
+		},
+		{
+			name: "время из будущего",
+			tamper: func(req *http.Request) []byte {
+				resign(req, testServiceKey, time.Now().Add(MaxClockSkew+time.Minute), body)
+				return body
+			},
+			wantErr: ErrSignatureExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedTestRequest(t, body)
+			received := tt.tamper(req)
+
+			signed, err := VerifyRequest(req, received, testKeys)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyRequest = %v, ожидалось %v", err, tt.wantErr)
+			}
+			if err == nil && (signed.Service != "user-service" || signed.Nonce != req.Header.Get(HeaderNonce)) {
+				t.Errorf("неожиданный результат %+v", signed)
+			}
+		})
+	}
+}
+
+// Внутри окна MaxClockSkew повтор проходит проверку подписи, и отсечь его можно
+// только по nonce, поэтому nonce повтора должен совпадать, а у новых запросов - нет
+func TestVerifyRequestReplayWithinSkew(t *testing.T) {
+	body := []byte(`{"user_id":"1"}`)
+	req := signedTestRequest(t, body)
+
+	first, err := VerifyRequest(req, body, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := req.Clone(req.Context())
+	second, err := VerifyRequest(replay, body, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Nonce != second.Nonce {
+		t.Errorf("nonce повтора %s отличается от исходного %s", second.Nonce, first.Nonce)
+	}
+
+	fresh, err := VerifyRequest(signedTestRequest(t, body), body, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Nonce == first.Nonce {
+		t.Error("у нового запроса тот же nonce")
+	}
+}
@@ -0,0 +1,253 @@
+package authkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const claimsContextKey = "authkit.claims"
+
+const defaultJWKSURL = "http://127.0.0.1:8001/.well-known/jwks.json"
+
+type Config struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	// RevocationsURL - лента отзывов токенов в auth-service
+	RevocationsURL         string
+	RevocationMaxStaleness time.Duration
+}
+
+// ConfigFromEnv собирает настройки из AUTH_JWKS_URL, JWT_ISSUER, JWT_AUDIENCE,
+// AUTH_REVOCATIONS_URL и AUTH_REVOCATION_MAX_STALENESS
+func ConfigFromEnv() Config {
+	staleness, err := time.ParseDuration(os.Getenv("AUTH_REVOCATION_MAX_STALENESS"))
+	if err != nil || staleness <= 0 {
+		staleness = defaultRevocationMaxStaleness
+	}
+
+	return Config{
+		JWKSURL:                getEnv("AUTH_JWKS_URL", defaultJWKSURL),
+		Issuer:                 getEnv("JWT_ISSUER", "auth-service"),
+		Audience:               getEnv("JWT_AUDIENCE", "u2"),
+		RevocationsURL:         getEnv("AUTH_REVOCATIONS_URL", defaultRevocationsURL),
+		RevocationMaxStaleness: staleness,
+	}
+}
+
+// Authenticator проверяет access токены локально: подпись - по публичным ключам auth-service,
+// отзыв - по ленте отзывов, которую он читает в фоне
+type Authenticator struct {
+	cfg        Config
+	jwks       *JWKSCache
+	revocation *revocationFeed
+}
+
+func NewAuthenticator(cfg Config) *Authenticator {
+	if cfg.RevocationsURL == "" {
+		cfg.RevocationsURL = defaultRevocationsURL
+	}
+	if cfg.RevocationMaxStaleness <= 0 {
+		cfg.RevocationMaxStaleness = defaultRevocationMaxStaleness
+	}
+	revocation := newRevocationFeed(cfg.RevocationsURL, cfg.RevocationMaxStaleness)
+	go revocation.run(context.Background())
+
+	return &Authenticator{
+		cfg:        cfg,
+		jwks:       NewJWKSCache(cfg.JWKSURL),
+		revocation: revocation,
+	}
+}
+
+// Verify проверяет подпись, издателя, аудиторию и срок токена, а затем его отзыв по ленте
+func (a *Authenticator) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.jwks.Key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(a.cfg.Issuer),
+		jwt.WithAudience(a.cfg.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("токен невалиден")
+	}
+	if err := a.revocation.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Middleware требует заголовок Authorization: Bearer <token> и кладет claims в контекст
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := BearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return
+		}
+
+		claims, err := a.Verify(c.Request.Context(), tokenString)
+		if errors.Is(err, ErrAuthUnavailable) {
+			// лента отзывов устарела - запрос не пропускается
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Сервис авторизации недоступен, попробуйте позже"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Проблема с авторизацией"})
+			return
+		}
+
+		c.Set(claimsContextKey, claims)
+		c.Next()
+	}
+}
+
+// BearerToken достает токен из значения заголовка Authorization
+func BearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// ClaimsFromContext возвращает claims, положенные Middleware
+func ClaimsFromContext(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get(claimsContextKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// RequireSelf пропускает запрос, только если параметр пути совпадает с user_id из токена
+func RequireSelf(param string) gin.HandlerFunc {
+	return requireParam(param, func(claims *Claims) string { return claims.Subject })
+}
+
+// RequireAnketaOwner пропускает запрос, только если параметр пути совпадает с anketa_id из токена
+func RequireAnketaOwner(param string) gin.HandlerFunc {
+	return requireParam(param, func(claims *Claims) string { return claims.AnketaID })
+}
+
+// RequireRole пропускает запрос, только если в токене есть хотя бы одна из ролей
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := ClaimsFromContext(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return
+		}
+
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+	}
+}
+
+func requireParam(param string, field func(*Claims) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := ClaimsFromContext(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return
+		}
+
+		value := field(claims)
+		if value == "" || value != c.Param(param) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func getEnv(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// RequireInternal пропускает только запросы, подписанные ключом одного из сервисов keys.
+// Nonce запоминаются в памяти процесса, поэтому повтор отсекается в пределах одного экземпляра
+func RequireInternal(keys map[string][]byte) gin.HandlerFunc {
+	var mu sync.Mutex
+	seen := map[string]time.Time{}
+
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		signed, err := VerifyRequest(c.Request, body, keys)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Запрос не авторизован"})
+			return
+		}
+
+		now := time.Now()
+		nonce := signed.Service + ":" + signed.Nonce
+
+		mu.Lock()
+		for key, expiresAt := range seen {
+			if now.After(expiresAt) {
+				delete(seen, key)
+			}
+		}
+		_, replayed := seen[nonce]
+		if !replayed {
+			seen[nonce] = now.Add(2 * MaxClockSkew)
+		}
+		mu.Unlock()
+
+		if replayed {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Запрос не авторизован"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// TrustedProxiesFromEnv читает из TRUSTED_PROXIES адреса прокси, которым gin разрешает
+// передавать адрес клиента в X-Forwarded-For. Пустой список - не доверять никому
+func TrustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, item := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			proxies = append(proxies, item)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,124 @@
+package mongokit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// История изменений хранится отдельной коллекцией: одна запись - одно поле в одном обновлении.
+// Запись связана с сущностью полем entityField (user_id, anketa_id), а _id по порядку
+// вставки служит курсором страниц.
+
+var ErrInvalidHistoryCursor = errors.New("некорректный курсор истории")
+
+// ChangeRecord - запись истории изменений сущности EntityID
+type ChangeRecord struct {
+	ID        string
+	EntityID  string
+	Field     string
+	OldValue  any
+	NewValue  any
+	Actor     string
+	Version   int64
+	ChangedAt time.Time
+}
+
+type changeRecordDTO struct {
+	ID        bson.ObjectID `bson:"_id"`
+	Field     string        `bson:"field"`
+	OldValue  any           `bson:"old_value"`
+	NewValue  any           `bson:"new_value"`
+	Actor     string        `bson:"actor"`
+	Version   int64         `bson:"version"`
+	ChangedAt time.Time     `bson:"changed_at"`
+}
+
+type History struct {
+	collection  *mongo.Collection
+	entityField string
+}
+
+func NewHistory(collection *mongo.Collection, entityField string) *History {
+	return &History{collection, entityField}
+}
+
+// CreateIndexes создает индекс для выборки истории сущности страницами
+func (h *History) CreateIndexes(ctx context.Context) error {
+	_, err := h.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: h.entityField, Value: 1}, {Key: "_id", Value: -1}},
+	})
+	return err
+}
+
+// Insert дописывает записи; внутри транзакции ctx должен быть контекстом сессии
+func (h *History) Insert(ctx context.Context, records []ChangeRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	documents := make([]any, 0, len(records))
+	for _, record := range records {
+		documents = append(documents, bson.D{
+			{Key: "_id", Value: bson.NewObjectID()},
+			{Key: h.entityField, Value: record.EntityID},
+			{Key: "field", Value: record.Field},
+			{Key: "old_value", Value: record.OldValue},
+			{Key: "new_value", Value: record.NewValue},
+			{Key: "actor", Value: record.Actor},
+			{Key: "version", Value: record.Version},
+			{Key: "changed_at", Value: record.ChangedAt},
+		})
+	}
+	_, err := h.collection.InsertMany(ctx, documents)
+	return err
+}
+
+// Find отдает записи от новых к старым, начиная с записи перед before (пустой - с последней)
+func (h *History) Find(ctx context.Context, entityID, before string, limit int) ([]ChangeRecord, error) {
+	filter := bson.M{h.entityField: entityID}
+	if before != "" {
+		beforeID, err := bson.ObjectIDFromHex(before)
+		if err != nil {
+			return nil, ErrInvalidHistoryCursor
+		}
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := h.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dtos []changeRecordDTO
+	if err := cursor.All(ctx, &dtos); err != nil {
+		return nil, err
+	}
+
+	records := make([]ChangeRecord, 0, len(dtos))
+	for _, dto := range dtos {
+		records = append(records, ChangeRecord{
+			ID:        dto.ID.Hex(),
+			EntityID:  entityID,
+			Field:     dto.Field,
+			OldValue:  dto.OldValue,
+			NewValue:  dto.NewValue,
+			Actor:     dto.Actor,
+			Version:   dto.Version,
+			ChangedAt: dto.ChangedAt,
+		})
+	}
+	return records, nil
+}
+
+// DeleteByEntity стирает всю историю сущности
+func (h *History) DeleteByEntity(ctx context.Context, entityID string) error {
+	_, err := h.collection.DeleteMany(ctx, bson.M{h.entityField: entityID})
+	return err
+}
@@ -0,0 +1,32 @@
+package mongokit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// RequireTransactions проверяет, что MongoDB поддерживает многодокументные транзакции.
+// Они есть только у набора реплик или шардированного кластера, а сервисы пишут изменения
+// вместе с историей и outbox в одной транзакции. Одиночный mongod нужно
+// запускать как набор реплик из одного узла (--replSet rs0 и rs.initiate())
+func RequireTransactions(db *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := db.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("MongoDB запущена без набора реплик, транзакции недоступны")
+	}
+	return nil
+}
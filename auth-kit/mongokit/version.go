@@ -0,0 +1,11 @@
+package mongokit
+
+import "go.mongodb.org/mongo-driver/v2/bson"
+
+// VersionFilter ищет документ нужной версии; у документов, созданных до появления версий, поля нет
+func VersionFilter(version int64) any {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
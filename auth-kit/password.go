@@ -0,0 +1,246 @@
+package authkit
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher хеширует пароли и проверяет их. Алгоритм и параметры хранятся
+// в самом хеше, поэтому Verify понимает хеши любого поддерживаемого алгоритма
+// и сообщает, что хеш пора пересчитать текущим хешером.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (ok bool, needsRehash bool, err error)
+}
+
+var ErrUnknownHashFormat = errors.New("неизвестный формат хеша пароля")
+
+// Argon2Params - параметры argon2id; Memory в KiB
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLen: 32}
+
+// argon2Slots ограничивает число одновременных вычислений argon2id: каждое занимает
+// Memory KiB, и поток логинов без ограничения исчерпает память процесса
+var argon2Slots = make(chan struct{}, runtime.NumCPU())
+
+// SetArgon2Concurrency задает, сколько хешей argon2id считается одновременно.
+// Вызывается при старте, до первого хеширования
+func SetArgon2Concurrency(n int) {
+	argon2Slots = make(chan struct{}, n)
+}
+
+func argon2Key(password string, salt []byte, params Argon2Params, keyLen uint32) []byte {
+	slots := argon2Slots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, keyLen)
+}
+
+type Argon2idHasher struct {
+	params Argon2Params
+}
+
+func NewArgon2idHasher(params Argon2Params) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+// Hash возвращает хеш в формате PHC: $argon2id$v=19$m=65536,t=3,p=2$<соль>$<хеш>
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2Key(password, salt, h.params, h.params.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	if !isArgon2idHash(encoded) {
+		ok, err := verifyAny(password, encoded)
+		return ok, ok, err
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	actual := argon2Key(password, salt, params, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false, nil
+	}
+
+	outdated := params.Memory != h.params.Memory ||
+		params.Time != h.params.Time ||
+		params.Threads != h.params.Threads ||
+		uint32(len(key)) != h.params.KeyLen
+	return true, outdated, nil
+}
+
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	if !isBcryptHash(encoded) {
+		ok, err := verifyAny(password, encoded)
+		return ok, ok, err
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return true, err == nil && cost != h.cost, nil
+}
+
+// verifyAny проверяет пароль по хешу любого поддерживаемого алгоритма
+func verifyAny(password, encoded string) (bool, error) {
+	switch {
+	case isBcryptHash(encoded):
+		ok, _, err := NewBcryptHasher(bcrypt.DefaultCost).Verify(password, encoded)
+		return ok, err
+	case isArgon2idHash(encoded):
+		ok, _, err := NewArgon2idHasher(DefaultArgon2Params).Verify(password, encoded)
+		return ok, err
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func isArgon2idHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", соль, хеш
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// PasswordHasherFromEnv выбирает хешер по PASSWORD_HASHER (argon2id по умолчанию или bcrypt)
+// и его параметрам ARGON2_MEMORY_KB, ARGON2_TIME, ARGON2_THREADS, BCRYPT_COST.
+// ARGON2_MAX_CONCURRENCY ограничивает число одновременных хеширований argon2id
+// (по умолчанию число CPU) и действует и на проверку старых хешей argon2id
+func PasswordHasherFromEnv() (PasswordHasher, error) {
+	switch algorithm := getEnv("PASSWORD_HASHER", "argon2id"); algorithm {
+	case "argon2id":
+		params := DefaultArgon2Params
+
+		memory, err := uintEnv("ARGON2_MEMORY_KB", uint64(params.Memory), 32)
+		if err != nil {
+			return nil, err
+		}
+		iterations, err := uintEnv("ARGON2_TIME", uint64(params.Time), 32)
+		if err != nil {
+			return nil, err
+		}
+		threads, err := uintEnv("ARGON2_THREADS", uint64(params.Threads), 8)
+		if err != nil {
+			return nil, err
+		}
+		concurrency, err := uintEnv("ARGON2_MAX_CONCURRENCY", uint64(runtime.NumCPU()), 16)
+		if err != nil {
+			return nil, err
+		}
+
+		params.Memory = uint32(memory)
+		params.Time = uint32(iterations)
+		params.Threads = uint8(threads)
+		SetArgon2Concurrency(int(concurrency))
+		return NewArgon2idHasher(params), nil
+
+	case "bcrypt":
+		cost, err := uintEnv("BCRYPT_COST", uint64(bcrypt.DefaultCost), 8)
+		if err != nil {
+			return nil, err
+		}
+		if int(cost) < bcrypt.MinCost || int(cost) > bcrypt.MaxCost {
+			return nil, errors.New("BCRYPT_COST вне допустимого диапазона")
+		}
+		return NewBcryptHasher(int(cost)), nil
+
+	default:
+		return nil, errors.New("неизвестный PASSWORD_HASHER: " + algorithm)
+	}
+}
+
+func uintEnv(key string, fallback uint64, bits int) (uint64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.ParseUint(value, 10, bits)
+	if err != nil || number == 0 {
+		return 0, errors.New("некорректное значение " + key + ": " + value)
+	}
+	return number, nil
+}
@@ -0,0 +1,200 @@
+package authkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Подпись токена не говорит, отозван ли он: logout, завершение сессии, смена пароля,
+// ролей и деактивация аккаунта хранятся в auth-service. Он публикует их лентой /revocations:
+// отозванные jti, завершенные сессии sid и новые версии ver токенов пользователя.
+// Authenticator держит ленту в памяти, дочитывая ее в фоне, и проверяет отзыв локально,
+// без запроса на каждый токен. Если лента не обновлялась дольше RevocationMaxStaleness,
+// запросы отклоняются.
+
+var (
+	ErrTokenRevoked    = errors.New("токен отозван")
+	ErrAuthUnavailable = errors.New("не удалось проверить отзыв токена")
+)
+
+const defaultRevocationsURL = "http://127.0.0.1:8001/revocations"
+
+// defaultRevocationMaxStaleness - сколько токены принимаются без свежей ленты;
+// auth-service отвечает на ожидающий запрос не реже чем раз в 25 секунд
+const defaultRevocationMaxStaleness = time.Minute
+
+// пауза перед повтором, если auth-service не ответил
+const revocationRetryDelay = 2 * time.Second
+
+// Типы событий ленты отзывов
+const (
+	revocationJTI     = "jti"
+	revocationSession = "sid"
+	revocationVersion = "ver"
+)
+
+type revocationEvent struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Version   int64  `json:"ver"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type userVersion struct {
+	version   int64
+	expiresAt time.Time
+}
+
+// revocationFeed - копия ленты отзывов; каждая запись живет до истечения
+// последнего access токена, который она отзывает
+type revocationFeed struct {
+	url          string
+	maxStaleness time.Duration
+	client       *http.Client
+
+	mu       sync.RWMutex
+	jtis     map[string]time.Time
+	sessions map[string]time.Time
+	versions map[string]userVersion
+	cursor   string
+	syncedAt time.Time
+}
+
+func newRevocationFeed(url string, maxStaleness time.Duration) *revocationFeed {
+	return &revocationFeed{
+		url:          url,
+		maxStaleness: maxStaleness,
+		client:       &http.Client{Timeout: 40 * time.Second},
+		jtis:         map[string]time.Time{},
+		sessions:     map[string]time.Time{},
+		versions:     map[string]userVersion{},
+		cursor:       "0",
+	}
+}
+
+// check возвращает nil, если по ленте токен не отозван
+func (f *revocationFeed) check(claims *Claims) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if time.Since(f.syncedAt) > f.maxStaleness {
+		return ErrAuthUnavailable
+	}
+	if _, ok := f.jtis[claims.ID]; ok && claims.ID != "" {
+		return ErrTokenRevoked
+	}
+	// токены, выпущенные до появления сессий, не содержат sid
+	if _, ok := f.sessions[claims.SessionID]; ok && claims.SessionID != "" {
+		return ErrTokenRevoked
+	}
+	if current, ok := f.versions[claims.Subject]; ok && claims.Version < current.version {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// run дочитывает ленту, пока не отменен ctx. Первый запрос отвечает сразу, следующие
+// ждут новых событий на стороне auth-service
+func (f *revocationFeed) run(ctx context.Context) {
+	wait := false
+	for ctx.Err() == nil {
+		more, err := f.poll(ctx, wait)
+		if err != nil {
+			log.Println("Не удалось получить ленту отзывов токенов", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(revocationRetryDelay):
+			}
+			wait = false
+			continue
+		}
+		wait = !more
+	}
+}
+
+func (f *revocationFeed) poll(ctx context.Context, wait bool) (bool, error) {
+	f.mu.RLock()
+	query := url.Values{"after": {f.cursor}}
+	f.mu.RUnlock()
+	if wait {
+		query.Set("wait", "1")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("auth-service вернул статус %d", resp.StatusCode)
+	}
+
+	var page struct {
+		Cursor string            `json:"cursor"`
+		Events []revocationEvent `json:"events"`
+		More   bool              `json:"more"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return false, err
+	}
+
+	f.apply(page.Cursor, page.Events, !page.More, time.Now())
+	return page.More, nil
+}
+
+// apply добавляет события и выбрасывает истекшие записи. Лента считается свежей,
+// только когда прочитана до конца
+func (f *revocationFeed) apply(cursor string, events []revocationEvent, complete bool, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, event := range events {
+		expiresAt := time.Unix(event.ExpiresAt, 0)
+		switch event.Type {
+		case revocationJTI:
+			f.jtis[event.ID] = expiresAt
+		case revocationSession:
+			f.sessions[event.ID] = expiresAt
+		case revocationVersion:
+			if event.Version > f.versions[event.ID].version {
+				f.versions[event.ID] = userVersion{event.Version, expiresAt}
+			}
+		}
+	}
+	if cursor != "" {
+		f.cursor = cursor
+	}
+	if complete {
+		f.syncedAt = now
+	}
+
+	for jti, expiresAt := range f.jtis {
+		if now.After(expiresAt) {
+			delete(f.jtis, jti)
+		}
+	}
+	for sid, expiresAt := range f.sessions {
+		if now.After(expiresAt) {
+			delete(f.sessions, sid)
+		}
+	}
+	for userId, current := range f.versions {
+		if now.After(current.expiresAt) {
+			delete(f.versions, userId)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package authkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sender доставляет короткие сообщения (коды подтверждения) пользователю
+type Sender interface {
+	Send(ctx context.Context, to, message string) error
+}
+
+var ErrSenderNotConfigured = errors.New("канал доставки кодов не настроен")
+
+// SendersFromEnv собирает отправителей email (SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM)
+// и SMS (SMS_API_URL, SMS_API_KEY). Ненастроенный канал пишет коды в лог или файл SENDER_LOG_FILE
+// только при явном SENDER=log, иначе коды молча не доходили бы до пользователей
+func SendersFromEnv() (email Sender, sms Sender, err error) {
+	logAllowed := os.Getenv("SENDER") == "log"
+	fallback := NewLogSender(os.Getenv("SENDER_LOG_FILE"))
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		email = NewSMTPSender(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), getEnv("SMTP_FROM", "no-reply@u2.local"))
+	} else if logAllowed {
+		log.Println("ВНИМАНИЕ: SMTP_ADDR не задан, коды для email пишутся в лог (SENDER=log)")
+		email = fallback
+	} else {
+		return nil, nil, fmt.Errorf("%w: нужен SMTP_ADDR или SENDER=log", ErrSenderNotConfigured)
+	}
+
+	if url := os.Getenv("SMS_API_URL"); url != "" {
+		sms = NewHTTPSMSSender(url, os.Getenv("SMS_API_KEY"))
+	} else if logAllowed {
+		log.Println("ВНИМАНИЕ: SMS_API_URL не задан, коды для SMS пишутся в лог (SENDER=log)")
+		sms = fallback
+	} else {
+		return nil, nil, fmt.Errorf("%w: нужен SMS_API_URL или SENDER=log", ErrSenderNotConfigured)
+	}
+	return email, sms, nil
+}
+
+// SMTPSender отправляет коды письмом
+type SMTPSender struct {
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPSender(addr, username, password, from string) *SMTPSender {
+	return &SMTPSender{addr, username, password, from}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, to, message string) error {
+	host, _, _ := strings.Cut(s.addr, ":")
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	body := "From: " + s.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.BEncoding.Encode("UTF-8", "Код подтверждения") + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + message + "\r\n"
+
+	return smtp.SendMail(s.addr, auth, s.from, []string{to}, []byte(body))
+}
+
+// HTTPSMSSender отправляет SMS через HTTP API шлюза: POST {"to", "text"} с Bearer ключом
+type HTTPSMSSender struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewHTTPSMSSender(url, apiKey string) *HTTPSMSSender {
+	return &HTTPSMSSender{url, apiKey, &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *HTTPSMSSender) Send(ctx context.Context, to, message string) error {
+	payload, err := json.Marshal(map[string]string{"to": to, "text": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS шлюз вернул статус %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// LogSender для локальной разработки: пишет сообщения в лог или дописывает в файл
+type LogSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewLogSender(path string) *LogSender {
+	return &LogSender{path: path}
+}
+
+func (s *LogSender) Send(ctx context.Context, to, message string) error {
+	if s.path == "" {
+		log.Printf("[sender] %s: %s", to, message)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, message)
+	return err
+}
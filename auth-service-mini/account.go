@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Деактивированный или ожидающий удаления аккаунт помечается полем disabled в хеше
+// аккаунта: войти в него нельзя, выданные токены отзываются. Окончательное удаление
+// стирает хеш, индексы учетных данных, сессии и журнал аккаунта.
+// Поле pending держится до завершения регистрации в user-service и тоже закрывает вход.
+
+// KEYS[1] - хеш аккаунта, KEYS[2] - журнал аккаунта, KEYS[3..] - индексы учетных данных.
+// ARGV[1] - user_id, ARGV[2..4] - логин, email и телефон, по которым собраны индексы.
+// Индекс удаляется, только если указывает на этот аккаунт.
+// Возвращает -1, если учетные данные успели измениться
+var deleteAccountScript = redis.NewScript(`
+for i, credType in ipairs({'login', 'email', 'phone'}) do
+	if (redis.call('HGET', KEYS[1], credType) or '') ~= ARGV[i + 1] then
+		return -1
+	end
+end
+for i = 3, #KEYS do
+	if redis.call('GET', KEYS[i]) == ARGV[1] then
+		redis.call('DEL', KEYS[i])
+	end
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+return 1
+`)
+
+func isAccountDisabled(userId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value, err := redisClient.HGet(ctx, accountKey(userId), "disabled").Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return value == "1", err
+}
+
+func isAccountPending(userId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value, err := redisClient.HGet(ctx, accountKey(userId), "pending").Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return value == "1", err
+}
+
+// activateAccount снимает pending; хеш проверяется в скрипте, чтобы не создать его заново после удаления
+var activateAccountScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[1], 'pending')
+return 1
+`)
+
+func activateAccount(userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	activated, err := activateAccountScript.Run(ctx, redisClient, []string{accountKey(userId)}).Int()
+	if err != nil {
+		return err
+	}
+	if activated == 0 {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+// setAccountDisabled включает или выключает вход в аккаунт; при выключении все сессии отзываются
+func setAccountDisabled(userId string, disabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	exists, err := redisClient.Exists(ctx, accountKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return errAccountNotFound
+	}
+
+	if !disabled {
+		return redisClient.HDel(ctx, accountKey(userId), "disabled").Err()
+	}
+
+	err = redisClient.HSet(ctx, accountKey(userId), "disabled", "1").Err()
+	if err != nil {
+		return err
+	}
+	return revokeAllUserTokens(userId)
+}
+
+// deleteAccount окончательно удаляет аккаунт. Версия токенов остается до истечения
+// последнего access токена, чтобы уже выданные токены не стали снова валидными
+func deleteAccount(userId string) error {
+	err := revokeAllUserTokens(userId)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deleted := false
+	for attempt := 0; attempt < credentialScriptAttempts && !deleted; attempt++ {
+		values, indexKeys, err := currentCredentials(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		keys := append([]string{accountKey(userId), auditUserStreamKey(userId)}, indexKeys...)
+		result, err := deleteAccountScript.Run(ctx, redisClient, keys, append([]any{userId}, values...)...).Int()
+		if err != nil {
+			return err
+		}
+		deleted = result == 1
+	}
+	if !deleted {
+		return errCredentialsChanged
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, passwordlessKey(userId), passwordlessCooldownKey(userId))
+		pipe.Expire(ctx, tokenVersionKey(userId), appConfig.AccessTokenTTL)
+		return nil
+	})
+	return err
+}
+
+// rejectDisabledAccount отвечает 403, если аккаунт деактивирован. Ответ отдается уже после
+// проверки первого фактора, поэтому по флагу account_disabled клиент может предложить восстановление
+func rejectDisabledAccount(c *gin.Context, userId string) bool {
+	disabled, err := isAccountDisabled(userId)
+	if err != nil {
+		log.Printf("Не удалось проверить статус аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return true
+	}
+	if !disabled {
+		return false
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditLoginFailure, UserID: userId, Detail: "account_disabled"})
+	c.JSON(http.StatusForbidden, gin.H{"error": "Аккаунт деактивирован", "account_disabled": true})
+	return true
+}
+
+// rejectPendingAccount отвечает 403, если регистрация аккаунта еще не завершена
+func rejectPendingAccount(c *gin.Context, userId string) bool {
+	pending, err := isAccountPending(userId)
+	if err != nil {
+		log.Printf("Не удалось проверить статус аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return true
+	}
+	if !pending {
+		return false
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditLoginFailure, UserID: userId, Detail: "registration_pending"})
+	c.JSON(http.StatusForbidden, gin.H{"error": "Регистрация еще не завершена"})
+	return true
+}
+
+// activateAccountHandler вызывается user-service, когда регистрация пользователя завершена
+func activateAccountHandler(c *gin.Context) {
+	var request struct {
+		UserId string `json:"user_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	err := activateAccount(request.UserId)
+	if errors.Is(err, errAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка активации аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": request.UserId})
+}
+
+// setAccountDisabledHandler вызывается user-service при деактивации, удалении и восстановлении
+// пользователя; в ответе anketa_id, чтобы скрыть или удалить анкету
+func setAccountDisabledHandler(c *gin.Context) {
+	var request struct {
+		UserId   string `json:"user_id" binding:"required"`
+		Disabled bool   `json:"disabled"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	err := setAccountDisabled(request.UserId, request.Disabled)
+	if errors.Is(err, errAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка изменения статуса аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	event := auditAccountEnabled
+	if request.Disabled {
+		event = auditAccountDisabled
+	}
+	recordAuthEvent(c, authEvent{Type: event, UserID: request.UserId})
+
+	anketaId, _ := getAccountAnketaId(request.UserId)
+	c.JSON(http.StatusOK, gin.H{"user_id": request.UserId, "anketa_id": anketaId})
+}
+
+// deleteAccountHandler удаляет аккаунт; повторный вызов для удаленного аккаунта тоже успешен
+func deleteAccountHandler(c *gin.Context) {
+	var request struct {
+		UserId string `json:"user_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	err := deleteAccount(request.UserId)
+	if err != nil {
+		log.Printf("Ошибка удаления аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	// событие пишется только в общий журнал: журнал аккаунта уже удален
+	recordAuthEvent(c, authEvent{Type: auditAccountDeleted, Detail: request.UserId})
+	c.JSON(http.StatusOK, gin.H{"status": "Аккаунт удален"})
+}
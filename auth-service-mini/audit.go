@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// События аутентификации пишутся в общий поток auth:audit и, если аккаунт известен,
+// в поток аккаунта auth:audit:user:<id>. Оба потока обрезаются приблизительно (MAXLEN ~).
+
+const (
+	auditStreamKey     = "auth:audit"
+	auditUserStreamLen = 1000
+	auditPageMax       = 200
+)
+
+const (
+	auditLoginSuccess          = "login_success"
+	auditLoginFailure          = "login_failure"
+	auditMFAChallenge          = "mfa_challenge"
+	auditTokenRefresh          = "token_refresh"
+	auditRefreshReuse          = "refresh_reuse"
+	auditLogout                = "logout"
+	auditLogoutAll             = "logout_all"
+	auditSessionRevoked        = "session_revoked"
+	auditPasswordResetReq      = "password_reset_requested"
+	auditPasswordChanged       = "password_changed"
+	auditPasswordResetFailed   = "password_reset_failed"
+	auditCredentialLinked      = "credential_linked"
+	auditCredentialVerified    = "credential_verified"
+	auditMFAEnabled            = "mfa_enabled"
+	auditMFADisabled           = "mfa_disabled"
+	auditLoginUnlocked         = "login_unlocked"
+	auditRolesChanged          = "roles_changed"
+	auditPasswordlessRequested = "passwordless_requested"
+	auditCredentialsChanged    = "credentials_changed"
+	auditAccountDisabled       = "account_disabled"
+	auditAccountEnabled        = "account_enabled"
+	auditAccountDeleted        = "account_deleted"
+)
+
+type authEvent struct {
+	Type       string
+	UserID     string
+	CredType   string
+	Identifier string
+	SessionID  string
+	// причина неудачи или подробности изменения
+	Detail string
+}
+
+func auditUserStreamKey(userId string) string {
+	return "auth:audit:user:" + userId
+}
+
+// auditUserId находит аккаунт для события, если он существует
+func auditUserId(credType, identifier string) string {
+	userId, _ := getUserIdFromRedis(credType, identifier)
+	return userId
+}
+
+// maskIdentifier скрывает большую часть логина, email или телефона,
+// оставляя достаточно, чтобы пользователь узнал свои данные
+func maskIdentifier(credType, identifier string) string {
+	if identifier == "" {
+		return ""
+	}
+
+	switch credType {
+	case "email":
+		local, domain, found := strings.Cut(identifier, "@")
+		if !found || local == "" {
+			return "***"
+		}
+		return local[:1] + "***@" + domain
+	case "phone":
+		if len(identifier) <= 6 {
+			return "***"
+		}
+		return identifier[:2] + strings.Repeat("*", len(identifier)-6) + identifier[len(identifier)-4:]
+	default:
+		if len(identifier) <= 2 {
+			return "***"
+		}
+		return identifier[:2] + "***"
+	}
+}
+
+// recordAuthEvent дописывает событие в журнал; ошибка записи не должна ломать сам запрос
+func recordAuthEvent(c *gin.Context, event authEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	values := map[string]any{
+		"type":       event.Type,
+		"user_id":    event.UserID,
+		"cred_type":  event.CredType,
+		"identifier": maskIdentifier(event.CredType, event.Identifier),
+		"session_id": event.SessionID,
+		"detail":     event.Detail,
+		"ts":         time.Now().Unix(),
+	}
+	if c != nil {
+		values["ip"] = clientIP(c)
+		values["user_agent"] = clientUserAgent(c)
+		if service, ok := c.Get(internalServiceContextKey); ok {
+			values["service"] = service
+		}
+	}
+
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: auditStreamKey,
+			MaxLen: appConfig.AuditMaxLen,
+			Approx: true,
+			Values: values,
+		})
+		if event.UserID != "" {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: auditUserStreamKey(event.UserID),
+				MaxLen: auditUserStreamLen,
+				Approx: true,
+				Values: values,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Не удалось записать событие аудита %s: %v", event.Type, err)
+	}
+}
+
+// accountAuditEvents возвращает события аккаунта от новых к старым, начиная с before (не включая)
+func accountAuditEvents(userId, before string, limit int64) ([]gin.H, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	end := "+"
+	if before != "" {
+		end = "(" + before
+	}
+
+	messages, err := redisClient.XRevRangeN(ctx, auditUserStreamKey(userId), end, "-", limit).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	events := make([]gin.H, 0, len(messages))
+	for _, message := range messages {
+		event := gin.H{"id": message.ID}
+		for field, value := range message.Values {
+			if field == "user_id" {
+				continue
+			}
+			event[field] = value
+		}
+		events = append(events, event)
+	}
+
+	next := ""
+	if int64(len(messages)) == limit {
+		next = messages[len(messages)-1].ID
+	}
+	return events, next, nil
+}
+
+func respondAccountAudit(c *gin.Context, userId string) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный limit"})
+		return
+	}
+	limit = min(limit, auditPageMax)
+
+	events, next, err := accountAuditEvents(userId, c.Query("before"), limit)
+	if err != nil {
+		log.Printf("Ошибка чтения журнала аудита: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	response := gin.H{"events": events}
+	if next != "" {
+		response["next_before"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getAccountAudit - для поддержки: история входов и изменений любого аккаунта
+func getAccountAudit(c *gin.Context) {
+	respondAccountAudit(c, c.Param("user_id"))
+}
+
+// getOwnAudit - та же история для владельца аккаунта
+func getOwnAudit(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+	respondAccountAudit(c, claims.Subject)
+}
@@ -1,15 +1,61 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"log"
+	"net"
 	"net/http"
-	"time"
+	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("pidorok-key")
+const (
+	forwardedIPContextKey        = "forwarded_client_ip"
+	forwardedUserAgentContextKey = "forwarded_user_agent"
+)
+
+// internalLogin - вход, который user-service выполняет от имени своего клиента. Адрес и User-Agent
+// клиента приходят в подписанном теле, поэтому X-Forwarded-For принимать от user-service не нужно
+func internalLogin(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных для входа"})
+		return
+	}
+
+	var client struct {
+		ClientIP  string `json:"client_ip"`
+		UserAgent string `json:"user_agent"`
+	}
+	if err := json.Unmarshal(body, &client); err != nil || net.ParseIP(client.ClientIP) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нужно указать адрес клиента"})
+		return
+	}
+
+	c.Set(forwardedIPContextKey, client.ClientIP)
+	c.Set(forwardedUserAgentContextKey, client.UserAgent)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	login(c)
+}
+
+// clientIP - адрес клиента, для служебного входа переданный user-service
+func clientIP(c *gin.Context) string {
+	if ip := c.GetString(forwardedIPContextKey); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
+
+func clientUserAgent(c *gin.Context) string {
+	if userAgent, ok := c.Get(forwardedUserAgentContextKey); ok {
+		return userAgent.(string)
+	}
+	return c.Request.UserAgent()
+}
 
 func login(c *gin.Context) {
 	log.Printf("=== НАЧАЛО АВТОРИЗАЦИИ ===")
@@ -27,21 +73,146 @@ func login(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Получен запрос на авторизацию: Creds=%s, Value=%s", request.Creds, request.Value)
+	log.Printf("Получен запрос на авторизацию: Creds=%s, Value=%s", request.Creds, maskIdentifier(request.Creds, request.Value))
+
+	credSubject := loginSubject(request.Creds, request.Value)
+	clientSubject := ipSubject(clientIP(c))
+
+	retryAfter, err := loginLockedFor(credSubject, clientSubject)
+	if err != nil {
+		log.Printf("Не удалось проверить блокировку входа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if retryAfter > 0 {
+		recordAuthEvent(c, authEvent{
+			Type: auditLoginFailure, UserID: auditUserId(request.Creds, request.Value),
+			CredType: request.Creds, Identifier: request.Value, Detail: "locked",
+		})
+		respondLoginLocked(c, retryAfter)
+		return
+	}
 
 	if !checkUserCreds(request.Creds, request.Value, request.Password) {
-		log.Printf("Проверка учетных данных не пройдена для %s: %s", request.Creds, request.Value)
+		log.Printf("Проверка учетных данных не пройдена для %s: %s", request.Creds, maskIdentifier(request.Creds, request.Value))
+		recordAuthEvent(c, authEvent{
+			Type: auditLoginFailure, UserID: auditUserId(request.Creds, request.Value),
+			CredType: request.Creds, Identifier: request.Value, Detail: "bad_credentials",
+		})
+
+		credLock, err := registerLoginFailure(credSubject, appConfig.LoginMaxFailures)
+		if err != nil {
+			log.Printf("Не удалось учесть неудачную попытку входа: %v", err)
+		}
+		ipLock, err := registerLoginFailure(clientSubject, appConfig.LoginIPMaxFailures)
+		if err != nil {
+			log.Printf("Не удалось учесть неудачную попытку входа: %v", err)
+		}
+
+		if lock := max(credLock, ipLock); lock > 0 {
+			log.Printf("Вход заблокирован на %s для %s: %s", lock, request.Creds, maskIdentifier(request.Creds, request.Value))
+			respondLoginLocked(c, lock)
+			return
+		}
+
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверные данные для входа"})
 		return
 	}
 
+	err = resetLoginFailures(credSubject)
+	if err != nil {
+		log.Printf("Не удалось сбросить счетчик попыток входа: %v", err)
+	}
+
+	if slices.Contains(appConfig.RequireVerifiedCredentials, request.Creds) {
+		verified, err := isCredentialVerified(request.Creds, request.Value)
+		if err != nil {
+			log.Printf("Не удалось проверить подтверждение учетных данных: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+			return
+		}
+		if !verified {
+			recordAuthEvent(c, authEvent{
+				Type: auditLoginFailure, UserID: auditUserId(request.Creds, request.Value),
+				CredType: request.Creds, Identifier: request.Value, Detail: "unverified",
+			})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Учетные данные не подтверждены"})
+			return
+		}
+	}
+
 	log.Printf("Учетные данные проверены успешно")
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"exp": time.Now().Add(40 * time.Second).Unix(), // поменять время истечения токена
-	})
+	userId, err := getUserIdFromRedis(request.Creds, request.Value)
+	if err != nil {
+		log.Printf("Не найден user_id для %s: %s", request.Creds, maskIdentifier(request.Creds, request.Value))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	completeLogin(c, userId, request.Creds, request.Value)
+}
+
+// completeLogin завершает вход после проверки первого фактора: при включенной 2FA
+// выдает mfa_token, иначе сразу пару токенов
+func completeLogin(c *gin.Context, userId, credType, identifier string) {
+	if rejectPendingAccount(c, userId) || rejectDisabledAccount(c, userId) {
+		return
+	}
+
+	enabled, err := isTOTPEnabled(userId)
+	if err != nil {
+		log.Printf("Не удалось проверить статус 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if enabled {
+		mfaToken, err := createMFAChallenge(userId, credType, identifier)
+		if err != nil {
+			log.Printf("Ошибка создания mfa_token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+			return
+		}
+
+		recordAuthEvent(c, authEvent{
+			Type: auditMFAChallenge, UserID: userId, CredType: credType, Identifier: identifier,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(mfaTokenTTL.Seconds()),
+		})
+		return
+	}
+
+	issueLoginTokens(c, userId, credType, identifier)
+}
+
+// issueLoginTokens выдает пару access/refresh токенов после успешной проверки всех факторов
+func issueLoginTokens(c *gin.Context, userId, credType, identifier string) {
+	// аккаунт могли деактивировать, пока пользователь вводил второй фактор
+	if rejectDisabledAccount(c, userId) {
+		return
+	}
+
+	anketaId, _ := getAnketaIdFromRedis(credType, identifier)
+
+	roles, err := getAccountRoles(userId)
+	if err != nil {
+		log.Printf("Ошибка получения ролей: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	refreshToken, sessionId, err := createRefreshFamily(userId, credType, identifier, deviceFromRequest(c))
+	if err != nil {
+		log.Printf("Ошибка сохранения refresh токена: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	tokenString, err := issueAccessToken(tokenIdentity{UserID: userId, AnketaID: anketaId, Roles: roles, SessionID: sessionId})
 	if err != nil {
 		log.Printf("Ошибка генерации токена: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
@@ -49,15 +220,16 @@ func login(c *gin.Context) {
 	}
 
 	log.Printf("Токен успешно сгенерирован")
-
-	// Получаем user_id и anketa_id из Redis
-	userId, _ := getUserIdFromRedis(request.Creds, request.Value)
-	anketaId, _ := getAnketaIdFromRedis(request.Creds, request.Value)
+	recordAuthEvent(c, authEvent{
+		Type: auditLoginSuccess, UserID: userId, CredType: credType, Identifier: identifier, SessionID: sessionId,
+	})
 
 	// Формируем ответ
-	response := gin.H{"token": tokenString}
-	if userId != "" {
-		response["user_id"] = userId
+	response := gin.H{
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(appConfig.AccessTokenTTL.Seconds()),
+		"user_id":       userId,
 	}
 	if anketaId != "" {
 		response["anketa_id"] = anketaId
@@ -66,40 +238,136 @@ func login(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-func authMiddleware(c *gin.Context) {
+func refresh(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
 
-	authHeader := c.GetHeader("AuthHeader")
+	refreshToken, family, err := rotateRefreshToken(request.RefreshToken, deviceFromRequest(c))
+	if err != nil {
+		switch err {
+		case errRefreshTokenReused:
+			log.Printf("Повторное использование refresh токена, семейство отозвано")
+			recordAuthEvent(c, authEvent{
+				Type: auditRefreshReuse, UserID: family.UserID, CredType: family.CredType,
+				Identifier: family.Identifier, SessionID: family.ID,
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия отозвана, войдите заново"})
+		case errRefreshTokenInvalid:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh токен невалиден или истек"})
+		default:
+			log.Printf("Ошибка ротации refresh токена: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		}
+		return
+	}
 
-	log.Println("заголовок запроса:", authHeader)
+	// аккаунт ищется по user_id: учетные данные, по которым был вход, могли смениться
+	userId := family.UserID
+	anketaId, err := getAccountAnketaId(userId)
+	if err != nil {
+		log.Printf("Не найден аккаунт для семейства refresh токенов %s", family.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh токен невалиден или истек"})
+		return
+	}
+
+	roles, err := getAccountRoles(userId)
+	if err != nil {
+		log.Printf("Ошибка получения ролей: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	tokenString, err := issueAccessToken(tokenIdentity{UserID: userId, AnketaID: anketaId, Roles: roles, SessionID: family.ID})
+	if err != nil {
+		log.Printf("Ошибка генерации токена: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditTokenRefresh, UserID: userId, SessionID: family.ID})
+
+	response := gin.H{
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(appConfig.AccessTokenTTL.Seconds()),
+		"user_id":       userId,
+	}
+	if anketaId != "" {
+		response["anketa_id"] = anketaId
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// authMiddleware проверяет access токен и кладет его claims в контекст
+func authMiddleware(c *gin.Context) {
+
+	// AuthHeader оставлен для старых клиентов, основной заголовок - Authorization
+	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка, проблема с авторизацией"})
+		authHeader = c.GetHeader("AuthHeader")
+	}
+
+	scheme, tokenString, found := strings.Cut(authHeader, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Ошибка, проблема с авторизацией"})
 		c.Abort()
 		return
 	}
 
-	tokenString := authHeader[7:]
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
-		return jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
+	claims, err := parseAccessToken(tokenString)
+	if err != nil {
 		log.Println("Произошла проблема при валидации токена |", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Проблема с авторизацией"})
 		c.Abort()
 		return
-	} else {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"exp": time.Now().Add(40 * time.Second).Unix(), // поменять время истечения токена
-		})
+	}
 
-		tokenString, err := token.SignedString(jwtSecret)
+	revoked, err := isTokenRevoked(claims.ID)
+	if err != nil {
+		log.Println("Не удалось проверить отзыв токена |", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		c.Abort()
+		return
+	}
+
+	version, err := getTokenVersion(claims.Subject)
+	if err != nil {
+		log.Println("Не удалось получить версию токенов пользователя |", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		c.Abort()
+		return
+	}
+
+	if revoked || claims.Version != version {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Токен отозван"})
+		c.Abort()
+		return
+	}
+
+	// токены, выпущенные до появления сессий, не содержат sid и живут до истечения
+	if claims.SessionID != "" {
+		active, err := touchSession(claims.SessionID)
 		if err != nil {
+			log.Println("Не удалось проверить сессию |", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия завершена"})
+			c.Abort()
+			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"token": tokenString, "status": "Токен верен"})
 	}
 
+	c.Set(claimsContextKey, claims)
 	c.Next()
 }
 
@@ -109,4 +377,65 @@ func verifyToken(c *gin.Context) {
 	if c.IsAborted() {
 		return
 	}
+
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "Токен верен",
+		"user_id":   claims.Subject,
+		"anketa_id": claims.AnketaID,
+		"roles":     claims.Roles,
+		"exp":       claims.ExpiresAt.Unix(),
+	})
+}
+
+func logout(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// тело необязательно: без refresh токена отзывается только access токен
+	_ = c.ShouldBindJSON(&request)
+
+	err := revokeToken(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		log.Printf("Ошибка отзыва токена: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	if claims.SessionID != "" {
+		err = revokeSession(claims.Subject, claims.SessionID)
+		if err != nil && err != errSessionNotFound {
+			log.Printf("Ошибка завершения сессии: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+			return
+		}
+	}
+
+	if request.RefreshToken != "" {
+		err = deleteRefreshFamily(claims.Subject, request.RefreshToken)
+		if err != nil && err != errRefreshTokenInvalid {
+			log.Printf("Ошибка отзыва refresh токена: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+			return
+		}
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditLogout, UserID: claims.Subject, SessionID: claims.SessionID})
+	c.JSON(http.StatusOK, gin.H{"message": "Выход выполнен"})
+}
+
+func logoutAll(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+
+	err := revokeAllUserTokens(claims.Subject)
+	if err != nil {
+		log.Printf("Ошибка отзыва всех токенов пользователя: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditLogoutAll, UserID: claims.Subject, SessionID: claims.SessionID})
+	c.JSON(http.StatusOK, gin.H{"message": "Выход выполнен на всех устройствах"})
 }
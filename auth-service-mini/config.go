@@ -1,7 +1,197 @@
 package main
 
-import "github.com/joho/godotenv"
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-kit"
+
+	"github.com/joho/godotenv"
+)
 
 func LoadEnv() error {
 	return godotenv.Load()
 }
+
+type Config struct {
+	AccessTokenTTL      time.Duration
+	RefreshTokenTTL     time.Duration
+	KeyRotationInterval time.Duration
+	Issuer              string
+	Audience            string
+	LoginMaxFailures    int
+	LoginIPMaxFailures  int
+	LoginFailureWindow  time.Duration
+	LoginLockoutBase    time.Duration
+	LoginLockoutMax     time.Duration
+	AdminToken          string
+	PasswordResetTTL    time.Duration
+	PasswordlessTTL     time.Duration
+	// адрес страницы входа по ссылке; без него отправляется только код
+	MagicLinkURL     string
+	InternalKeys     map[string][]byte
+	MFAEncryptionKey []byte
+	MFAIssuer        string
+	// приблизительный предел длины общего журнала аудита
+	AuditMaxLen int64
+	// типы учетных данных, вход по которым разрешен только после подтверждения
+	RequireVerifiedCredentials []string
+	// адреса прокси, которым разрешено передавать адрес клиента в X-Forwarded-For
+	TrustedProxies []string
+}
+
+var appConfig Config
+
+// LoadConfig читает настройки выпуска токенов из переменных окружения
+func LoadConfig() error {
+	ttl, err := getDurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	refreshTTL, err := getDurationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	rotationInterval, err := getDurationEnv("KEY_ROTATION_INTERVAL", 7*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	maxFailures, err := getIntEnv("LOGIN_MAX_FAILURES", 5)
+	if err != nil {
+		return err
+	}
+
+	ipMaxFailures, err := getIntEnv("LOGIN_IP_MAX_FAILURES", 50)
+	if err != nil {
+		return err
+	}
+
+	failureWindow, err := getDurationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	lockoutBase, err := getDurationEnv("LOGIN_LOCKOUT_BASE", time.Minute)
+	if err != nil {
+		return err
+	}
+
+	lockoutMax, err := getDurationEnv("LOGIN_LOCKOUT_MAX", time.Hour)
+	if err != nil {
+		return err
+	}
+
+	resetTTL, err := getDurationEnv("PASSWORD_RESET_TTL", 15*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	passwordlessTTL, err := getDurationEnv("PASSWORDLESS_TTL", 10*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	internalKeys, err := authkit.ParseServiceKeys(os.Getenv("INTERNAL_KEYS"))
+	if err != nil {
+		return errors.New("некорректное значение INTERNAL_KEYS: " + err.Error())
+	}
+
+	// ключ AES-256 для секретов TOTP в base64; без него 2FA нельзя подключить
+	var mfaKey []byte
+	if encoded := os.Getenv("MFA_ENCRYPTION_KEY"); encoded != "" {
+		mfaKey, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(mfaKey) != 32 {
+			return errors.New("MFA_ENCRYPTION_KEY должен быть 32 байтами в base64")
+		}
+	}
+
+	auditMaxLen, err := getIntEnv("AUDIT_MAX_LEN", 100000)
+	if err != nil {
+		return err
+	}
+
+	passwordHasher, err = authkit.PasswordHasherFromEnv()
+	if err != nil {
+		return err
+	}
+
+	appConfig = Config{
+		AccessTokenTTL:             ttl,
+		RefreshTokenTTL:            refreshTTL,
+		KeyRotationInterval:        rotationInterval,
+		Issuer:                     getEnv("JWT_ISSUER", "auth-service"),
+		Audience:                   getEnv("JWT_AUDIENCE", "u2"),
+		LoginMaxFailures:           maxFailures,
+		LoginIPMaxFailures:         ipMaxFailures,
+		LoginFailureWindow:         failureWindow,
+		LoginLockoutBase:           lockoutBase,
+		LoginLockoutMax:            lockoutMax,
+		AdminToken:                 os.Getenv("ADMIN_TOKEN"),
+		PasswordResetTTL:           resetTTL,
+		PasswordlessTTL:            passwordlessTTL,
+		MagicLinkURL:               os.Getenv("MAGIC_LINK_URL"),
+		InternalKeys:               internalKeys,
+		MFAEncryptionKey:           mfaKey,
+		MFAIssuer:                  getEnv("MFA_ISSUER", "u2"),
+		RequireVerifiedCredentials: getListEnv("REQUIRE_VERIFIED_CREDENTIALS"),
+		AuditMaxLen:                int64(auditMaxLen),
+		TrustedProxies:             authkit.TrustedProxiesFromEnv(),
+	}
+
+	return nil
+}
+
+func getEnv(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func getDurationEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New("некорректное значение " + key + ": " + err.Error())
+	}
+
+	return duration, nil
+}
+
+func getIntEnv(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return 0, errors.New("некорректное значение " + key + ": " + value)
+	}
+
+	return number, nil
+}
+
+// getListEnv читает список значений через запятую
+func getListEnv(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -2,18 +2,198 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 	"time"
 
+	"auth-kit"
+
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var redisClient *redis.Client
 
+var passwordHasher authkit.PasswordHasher
+
+var (
+	errAccountNotFound = errors.New("аккаунт не найден")
+	errCredentialTaken = errors.New("учетные данные уже привязаны к другому аккаунту")
+	errInvalidCredType = errors.New("invalid credential type")
+	credentialTypes    = []string{"login", "email", "phone"}
+	// учетные данные аккаунта изменились между чтением и скриптом
+	errCredentialsChanged = errors.New("учетные данные аккаунта изменились")
+	// аккаунт уже не хранит значения, при которых изменение было запрошено
+	errCredentialsMismatch = errors.New("учетные данные аккаунта не совпадают с ожидаемыми")
+)
+
+// скрипты, которые трогают индексы текущих учетных данных, получают их в KEYS заранее
+// и проверяют, что значения в хеше не изменились; иначе чтение повторяется
+const credentialScriptAttempts = 3
+
+// Учетная запись хранится одним хешем account:{user_id}, а каждый логин,
+// email и телефон - отдельным индексом auth:index:{тип}:{значение} -> user_id
+
+func accountKey(userId string) string {
+	return "account:" + userId
+}
+
+func credentialIndexKey(credType, identifier string) (string, error) {
+	switch credType {
+	case "login", "email", "phone":
+		return "auth:index:" + credType + ":" + identifier, nil
+	default:
+		return "", fmt.Errorf("%w: %s", errInvalidCredType, credType)
+	}
+}
+
+// KEYS[1] - хеш аккаунта, KEYS[2..] - индексы учетных данных
+// ARGV[1] - user_id, ARGV[2..] - пары поле/значение для хеша
+var saveAccountScript = redis.NewScript(`
+for i = 2, #KEYS do
+	local owner = redis.call('GET', KEYS[i])
+	if owner and owner ~= ARGV[1] then
+		return 0
+	end
+end
+for i = 2, #KEYS do
+	redis.call('SET', KEYS[i], ARGV[1])
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+return 1
+`)
+
+// Переносит аккаунт под новый user_id вместе с сессиями, версией токенов, журналом
+// и остальными ключами пользователя и перенаправляет на него все индексы.
+// KEYS[1] - старый хеш, KEYS[2] - новый хеш, KEYS[3..2+2m] - пары старый/новый ключ
+// из relinkedUserKeys (первая пара - множество семейств refresh токенов), затем f ключей
+// семейств, затем индексы текущих учетных данных.
+// ARGV[1] - новый user_id, ARGV[2..4] - текущие логин, email и телефон (пустая строка - нет),
+// ARGV[5] - m, ARGV[6] - f, ARGV[7..] - id семейств в порядке их ключей.
+// Возвращает 0, если новый user_id уже занят, -1, если учетные данные или сессии успели измениться
+var relinkAccountScript = redis.NewScript(`
+local pairCount = tonumber(ARGV[5])
+local familyCount = tonumber(ARGV[6])
+local firstFamily = 3 + pairCount * 2
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+for i = 4, 2 + pairCount * 2, 2 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		return 0
+	end
+end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+for i, credType in ipairs({'login', 'email', 'phone'}) do
+	if (redis.call('HGET', KEYS[1], credType) or '') ~= ARGV[i + 1] then
+		return -1
+	end
+end
+if redis.call('SCARD', KEYS[3]) ~= familyCount then
+	return -1
+end
+for i = 1, familyCount do
+	if redis.call('SISMEMBER', KEYS[3], ARGV[6 + i]) == 0 then
+		return -1
+	end
+end
+redis.call('RENAME', KEYS[1], KEYS[2])
+redis.call('HSET', KEYS[2], 'user_id', ARGV[1])
+for i = 3, 2 + pairCount * 2, 2 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[i + 1])
+	end
+end
+for i = firstFamily, firstFamily + familyCount - 1 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('HSET', KEYS[i], 'user_id', ARGV[1])
+	end
+end
+for i = firstFamily + familyCount, #KEYS do
+	redis.call('SET', KEYS[i], ARGV[1])
+end
+return 1
+`)
+
+// relinkedUserKeys - пары старый/новый ключ данных, привязанных к user_id. Множество
+// семейств refresh токенов идет первым: по нему скрипт сверяет список сессий
+func relinkedUserKeys(oldId, newId string) []string {
+	keyFuncs := []func(string) string{
+		userRefreshFamiliesKey,
+		tokenVersionKey,
+		auditUserStreamKey,
+		recoveryCodesKey,
+		passwordResetKey,
+		passwordResetCooldownKey,
+		passwordlessKey,
+		passwordlessCooldownKey,
+	}
+	keys := make([]string, 0, len(keyFuncs)*2)
+	for _, key := range keyFuncs {
+		keys = append(keys, key(oldId), key(newId))
+	}
+	return keys
+}
+
+// Меняет учетные данные аккаунта: старые индексы удаляются, новые указывают на аккаунт.
+// Новый email или телефон считается неподтвержденным, если статус не передан явно.
+// KEYS[1] - хеш аккаунта, KEYS[2..n+1] - новые индексы, KEYS[n+2..] - индексы заменяемых значений
+// ARGV[1] - user_id, ARGV[2] - новый хеш пароля или пустая строка, ARGV[3] и ARGV[4] - статусы
+// подтверждения email и телефона ('1', '0' или пустая строка), ARGV[5..7] - текущие логин,
+// email и телефон, ARGV[8] - ожидаемый хеш пароля или пустая строка, ARGV[9] - n,
+// далее пары тип/значение в порядке KEYS[2..n+1].
+// Возвращает -1, если аккаунта нет, 0, если значение занято, -2, если учетные данные успели измениться,
+// -3, если хеш пароля не совпал с ожидаемым
+var updateCredentialsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+for i, credType in ipairs({'login', 'email', 'phone'}) do
+	if (redis.call('HGET', KEYS[1], credType) or '') ~= ARGV[i + 4] then
+		return -2
+	end
+end
+if ARGV[8] ~= '' and redis.call('HGET', KEYS[1], 'password_hash') ~= ARGV[8] then
+	return -3
+end
+local n = tonumber(ARGV[9])
+for i = 2, n + 1 do
+	local owner = redis.call('GET', KEYS[i])
+	if owner and owner ~= ARGV[1] then
+		return 0
+	end
+end
+for i = n + 2, #KEYS do
+	if redis.call('GET', KEYS[i]) == ARGV[1] then
+		redis.call('DEL', KEYS[i])
+	end
+end
+for i = 2, n + 1 do
+	local credType = ARGV[8 + (i - 1) * 2]
+	local value = ARGV[9 + (i - 1) * 2]
+	if (redis.call('HGET', KEYS[1], credType) or '') ~= value then
+		redis.call('SET', KEYS[i], ARGV[1])
+		redis.call('HSET', KEYS[1], credType, value)
+		if credType ~= 'login' then
+			redis.call('HSET', KEYS[1], credType .. '_verified', '0')
+		end
+	end
+end
+if ARGV[3] ~= '' then
+	redis.call('HSET', KEYS[1], 'email_verified', ARGV[3])
+end
+if ARGV[4] ~= '' then
+	redis.call('HSET', KEYS[1], 'phone_verified', ARGV[4])
+end
+if ARGV[2] ~= '' then
+	redis.call('HSET', KEYS[1], 'password_hash', ARGV[2])
+end
+return 1
+`)
+
 func initDatabase() error {
 
 	redisClient = redis.NewClient(&redis.Options{
@@ -35,339 +215,475 @@ func initDatabase() error {
 	return nil
 }
 
-func checkUserCreds(credType string, identifier string, inputPassword string) bool {
-	log.Printf("=== ПРОВЕРКА УЧЕТНЫХ ДАННЫХ ===")
-	log.Printf("Тип: %s, Идентификатор: %s", credType, identifier)
-	
-	storedHash, err := getPasswordHashFromRedis(credType, identifier)
+// resolveAccountId находит user_id аккаунта по любому из его учетных данных
+func resolveAccountId(ctx context.Context, credType, identifier string) (string, error) {
+	indexKey, err := credentialIndexKey(credType, identifier)
 	if err != nil {
-		log.Printf("User not found: %s %s", credType, identifier)
-		return false
+		return "", err
 	}
 
-	log.Printf("Найден хеш пароля в Redis")
-	
-	err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(inputPassword))
+	userId, err := redisClient.Get(ctx, indexKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", errAccountNotFound
+	}
+	return userId, err
+}
+
+func getAccountField(credType, identifier, field string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
 	if err != nil {
-		log.Printf("Неверный пароль")
-		return false
+		return "", err
 	}
 
-	log.Printf("Пароль верный!")
-	return true
+	value, err := redisClient.HGet(ctx, accountKey(userId), field).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", errAccountNotFound
+	}
+	return value, err
 }
 
-func getPasswordHashFromRedis(credType string, identifier string) (string, error) {
+func setAccountField(credType, identifier, field, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
+	if err != nil {
+		return err
+	}
 
+	return redisClient.HSet(ctx, accountKey(userId), field, value).Err()
+}
+
+// saveAccount атомарно записывает аккаунт и индексы его учетных данных. Аккаунт создается
+// с полем pending: войти в него можно только после того, как user-service завершит регистрацию
+func saveAccount(userId, login, email, phone, passwordHash string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var redisKey string
+	keys := []string{accountKey(userId)}
+	fields := map[string]string{"login": login, "email": email, "phone": phone}
+	for _, credType := range credentialTypes {
+		if fields[credType] == "" {
+			continue
+		}
+		indexKey, _ := credentialIndexKey(credType, fields[credType])
+		keys = append(keys, indexKey)
+	}
 
-	switch credType {
-	case "email":
-		redisKey = "auth:email:" + identifier
-		log.Printf("Ищем по email: %s", redisKey)
-	case "login":
-		redisKey = "auth:login:" + identifier
-		log.Printf("Ищем по логину: %s", redisKey)
-	case "phone":
-		redisKey = "auth:phone:" + identifier
-		log.Printf("Ищем по телефону: %s", redisKey)
-	default:
-		return "", fmt.Errorf("invalid credential type: %s", credType)
+	args := []any{
+		userId,
+		"user_id", userId,
+		"login", login,
+		"email", email,
+		"phone", phone,
+		"password_hash", passwordHash,
+		"pending", "1",
 	}
 
-	storedHash, err := redisClient.Get(ctx, redisKey).Result()
+	saved, err := saveAccountScript.Run(ctx, redisClient, keys, args...).Int()
 	if err != nil {
-		log.Printf("Ошибка получения из Redis: %s - %v", redisKey, err)
-		return "", fmt.Errorf("not found in Redis: %s", redisKey)
+		return err
+	}
+	if saved == 0 {
+		return errCredentialTaken
 	}
 
-	log.Printf("Найден хеш в Redis для ключа: %s", redisKey)
-	return storedHash, nil
+	return nil
 }
 
-func saveShitToRedis(login, email, phone, password string) error {
-	ctx := context.Background()
+// Пароль меняется, только если хеш за время пересчета не заменили (например, сбросом пароля)
+var rehashPasswordScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'password_hash') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'password_hash', ARGV[2])
+return 1
+`)
 
-	key := fmt.Sprintf("auth:login:%s", login)
-	log.Println("добавляем:", key)
-	err := redisClient.Set(ctx, key, password, 0).Err()
+func checkUserCreds(credType string, identifier string, inputPassword string) bool {
+	storedHash, err := getPasswordHashFromRedis(credType, identifier)
+	if err != nil {
+		log.Printf("Аккаунт не найден по типу %s", credType)
+		return false
+	}
+
+	ok, needsRehash, err := passwordHasher.Verify(inputPassword, storedHash)
+	if err != nil {
+		log.Printf("Ошибка проверки пароля: %v", err)
+		return false
+	}
+	if !ok {
+		log.Printf("Неверный пароль")
+		return false
+	}
+
+	if needsRehash {
+		err = rehashPassword(credType, identifier, storedHash, inputPassword)
+		if err != nil {
+			log.Printf("Не удалось обновить хеш пароля: %v", err)
+		}
+	}
+
+	return true
+}
+
+// rehashPassword пересчитывает хеш устаревшего алгоритма или параметров текущим хешером
+func rehashPassword(credType, identifier, oldHash, password string) error {
+	newHash, err := passwordHasher.Hash(password)
 	if err != nil {
-		log.Printf("Ошибка сохранения пароля по логину: %v", err)
 		return err
 	}
 
-	key = fmt.Sprintf("auth:email:%s", email)
-	log.Println("добавляем:", key)
-	err = redisClient.Set(ctx, key, password, 0).Err()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
 	if err != nil {
-		log.Printf("Ошибка сохранения пароля по email: %v", err)
 		return err
 	}
 
-	key = fmt.Sprintf("auth:phone:%s", phone)
-	log.Println("добавляем:", key)
-	err = redisClient.Set(ctx, key, password, 0).Err()
+	return rehashPasswordScript.Run(ctx, redisClient, []string{accountKey(userId)}, oldHash, newHash).Err()
+}
+
+func getPasswordHashFromRedis(credType string, identifier string) (string, error) {
+	return getAccountField(credType, identifier, "password_hash")
+}
+
+func saveShitToRedis(userId, login, email, phone, password string) error {
+	err := saveAccount(userId, login, email, phone, password)
 	if err != nil {
-		log.Printf("Ошибка сохранения пароля по телефону: %v", err)
+		log.Printf("Ошибка сохранения аккаунта: %v", err)
 		return err
 	}
 
-	log.Println("Пароли успешно сохранены в Redis")
+	log.Println("Аккаунт успешно сохранен в Redis")
 	return nil
 }
 
 func saveAnketaIdToRedis(credType, identifier, anketaId string) error {
-	ctx := context.Background()
-	
-	var redisKey string
-	
-	switch credType {
-	case "email":
-		redisKey = "auth:email:" + identifier + ":anketa_id"
-		log.Printf("Сохраняем ID анкеты по email: %s", redisKey)
-	case "login":
-		redisKey = "auth:login:" + identifier + ":anketa_id"
-		log.Printf("Сохраняем ID анкеты по логину: %s", redisKey)
-	case "phone":
-		redisKey = "auth:phone:" + identifier + ":anketa_id"
-		log.Printf("Сохраняем ID анкеты по телефону: %s", redisKey)
-	default:
-		return fmt.Errorf("invalid credential type: %s", credType)
-	}
-	
-	err := redisClient.Set(ctx, redisKey, anketaId, 0).Err()
+	err := setAccountField(credType, identifier, "anketa_id", anketaId)
 	if err != nil {
 		log.Printf("Ошибка сохранения ID анкеты: %v", err)
 		return err
 	}
-	
-	log.Printf("ID анкеты успешно сохранен: %s = %s", redisKey, anketaId)
+
+	log.Printf("ID анкеты успешно сохранен")
 	return nil
 }
 
+// Аккаунт один на все типы учетных данных, поэтому достаточно найти его по логину
 func saveAnketaIdToAllCredTypes(login, email, phone, anketaId string) error {
-	ctx := context.Background()
-	
-	// Сохраняем по логину (обязательно)
 	if login == "" {
 		return fmt.Errorf("login не может быть пустым")
 	}
-	key := fmt.Sprintf("auth:login:%s:anketa_id", login)
-	log.Printf("Сохраняем ID анкеты по логину: %s = %s", key, anketaId)
-	err := redisClient.Set(ctx, key, anketaId, 0).Err()
+
+	return saveAnketaIdToRedis("login", login, anketaId)
+}
+
+func getAnketaIdFromRedis(credType, identifier string) (string, error) {
+	anketaId, err := getAccountField(credType, identifier, "anketa_id")
 	if err != nil {
-		log.Printf("Ошибка сохранения ID анкеты по логину: %v", err)
-		return err
+		return "", err
 	}
-	
-	// Сохраняем по email (если не пустой)
-	if email != "" {
-		key = fmt.Sprintf("auth:email:%s:anketa_id", email)
-		log.Printf("Сохраняем ID анкеты по email: %s = %s", key, anketaId)
-		err = redisClient.Set(ctx, key, anketaId, 0).Err()
-		if err != nil {
-			log.Printf("Ошибка сохранения ID анкеты по email: %v", err)
-			return err
-		}
-	} else {
-		log.Printf("Email пустой, пропускаем сохранение по email")
-	}
-	
-	// Сохраняем по телефону (если не пустой)
-	if phone != "" {
-		key = fmt.Sprintf("auth:phone:%s:anketa_id", phone)
-		log.Printf("Сохраняем ID анкеты по телефону: %s = %s", key, anketaId)
-		err = redisClient.Set(ctx, key, anketaId, 0).Err()
-		if err != nil {
-			log.Printf("Ошибка сохранения ID анкеты по телефону: %v", err)
-			return err
-		}
-	} else {
-		log.Printf("Телефон пустой, пропускаем сохранение по телефону")
+	if anketaId == "" {
+		return "", errAccountNotFound
 	}
-	
-	log.Printf("ID анкеты успешно сохранен по всем типам учетных данных")
-	return nil
+	return anketaId, nil
 }
 
-func getAnketaIdFromRedis(credType, identifier string) (string, error) {
-	ctx := context.Background()
-	
-	var redisKey string
-	
-	switch credType {
-	case "email":
-		redisKey = "auth:email:" + identifier + ":anketa_id"
-		log.Printf("Ищем ID анкеты по email: %s", redisKey)
-	case "login":
-		redisKey = "auth:login:" + identifier + ":anketa_id"
-		log.Printf("Ищем ID анкеты по логину: %s", redisKey)
-	case "phone":
-		redisKey = "auth:phone:" + identifier + ":anketa_id"
-		log.Printf("Ищем ID анкеты по телефону: %s", redisKey)
-	default:
-		return "", fmt.Errorf("invalid credential type: %s", credType)
-	}
-	
-	anketaId, err := redisClient.Get(ctx, redisKey).Result()
+// getAccountAnketaId возвращает anketa_id аккаунта (пустой, если анкеты еще нет)
+func getAccountAnketaId(userId string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fields, err := redisClient.HMGet(ctx, accountKey(userId), "user_id", "anketa_id").Result()
 	if err != nil {
-		log.Printf("ID анкеты не найден для %s %s: %v", credType, identifier, err)
 		return "", err
 	}
-	
-	log.Printf("Найден ID анкеты: %s = %s", redisKey, anketaId)
+	if fields[0] == nil {
+		return "", errAccountNotFound
+	}
+
+	anketaId, _ := fields[1].(string)
 	return anketaId, nil
 }
 
 func getAllUserCreds(credType, identifier string) (login, email, phone string, err error) {
-	ctx := context.Background()
-	
-	// 1. Получаем пароль по переданным данным
-	password, err := getPasswordHashFromRedis(credType, identifier)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
 	if err != nil {
-		log.Printf("Ошибка получения пароля для %s %s: %v", credType, identifier, err)
 		return "", "", "", err
 	}
-	
-	log.Printf("Найден пароль для %s %s, ищем связанные учетные данные", credType, identifier)
-	
-	// 2. Ищем все ключи с этим паролем
-	patterns := []string{"auth:login:*", "auth:email:*", "auth:phone:*"}
-	
-	for _, pattern := range patterns {
-		keys, err := redisClient.Keys(ctx, pattern).Result()
-		if err != nil {
-			log.Printf("Ошибка поиска ключей по паттерну %s: %v", pattern, err)
-			continue
-		}
-		
-		for _, key := range keys {
-			storedPassword, err := redisClient.Get(ctx, key).Result()
-			if err != nil {
-				log.Printf("Ошибка получения значения для ключа %s: %v", key, err)
-				continue
-			}
-			
-			if storedPassword == password {
-				// Извлекаем тип и значение
-				parts := strings.Split(key, ":")
-				if len(parts) == 3 {
-					credType := parts[1]
-					credValue := parts[2]
-					
-					log.Printf("Найдено совпадение: %s = %s", key, credValue)
-					
-					switch credType {
-					case "login":
-						login = credValue
-					case "email":
-						email = credValue
-					case "phone":
-						phone = credValue
-					}
-				}
-			}
-		}
+
+	fields, err := redisClient.HMGet(ctx, accountKey(userId), "login", "email", "phone").Result()
+	if err != nil {
+		return "", "", "", err
 	}
-	
-	log.Printf("Результат поиска: login=%s, email=%s, phone=%s", login, email, phone)
+
+	login, _ = fields[0].(string)
+	email, _ = fields[1].(string)
+	phone, _ = fields[2].(string)
 	return login, email, phone, nil
 }
 
-// Сохранение user_id в Redis по одному типу учетных данных
+// Сохранение user_id: аккаунт переносится под новый user_id вместе с индексами и сессиями
 func saveUserIdToRedis(credType, identifier, userId string) error {
-	ctx := context.Background()
-	
-	var redisKey string
-	
-	switch credType {
-	case "email":
-		redisKey = "auth:email:" + identifier + ":user_id"
-		log.Printf("Сохраняем ID пользователя по email: %s", redisKey)
-	case "login":
-		redisKey = "auth:login:" + identifier + ":user_id"
-		log.Printf("Сохраняем ID пользователя по логину: %s", redisKey)
-	case "phone":
-		redisKey = "auth:phone:" + identifier + ":user_id"
-		log.Printf("Сохраняем ID пользователя по телефону: %s", redisKey)
-	default:
-		return fmt.Errorf("invalid credential type: %s", credType)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for attempt := 0; attempt < credentialScriptAttempts; attempt++ {
+		currentId, err := resolveAccountId(ctx, credType, identifier)
+		if err != nil {
+			return err
+		}
+		if currentId == userId {
+			return nil
+		}
+
+		values, indexKeys, err := currentCredentials(ctx, currentId)
+		if err != nil {
+			return err
+		}
+
+		familyIds, err := redisClient.SMembers(ctx, userRefreshFamiliesKey(currentId)).Result()
+		if err != nil {
+			return err
+		}
+
+		userKeys := relinkedUserKeys(currentId, userId)
+		keys := append([]string{accountKey(currentId), accountKey(userId)}, userKeys...)
+		args := append([]any{userId}, values...)
+		args = append(args, len(userKeys)/2, len(familyIds))
+		for _, familyId := range familyIds {
+			keys = append(keys, refreshFamilyKey(familyId))
+			args = append(args, familyId)
+		}
+		keys = append(keys, indexKeys...)
+
+		moved, err := relinkAccountScript.Run(ctx, redisClient, keys, args...).Int()
+		if err != nil {
+			log.Printf("Ошибка сохранения ID пользователя: %v", err)
+			return err
+		}
+		switch moved {
+		case 0:
+			return errCredentialTaken
+		case 1:
+			log.Printf("ID пользователя успешно сохранен")
+			return nil
+		}
 	}
-	
-	err := redisClient.Set(ctx, redisKey, userId, 0).Err()
+	return errCredentialsChanged
+}
+
+// currentCredentials читает логин, email и телефон аккаунта в порядке credentialTypes
+// и ключи индексов для непустых значений
+func currentCredentials(ctx context.Context, userId string) ([]any, []string, error) {
+	fields, err := redisClient.HMGet(ctx, accountKey(userId), credentialTypes...).Result()
 	if err != nil {
-		log.Printf("Ошибка сохранения ID пользователя: %v", err)
-		return err
+		return nil, nil, err
 	}
-	
-	log.Printf("ID пользователя успешно сохранен: %s = %s", redisKey, userId)
-	return nil
+
+	values := make([]any, 0, len(credentialTypes))
+	var indexKeys []string
+	for i, credType := range credentialTypes {
+		value, _ := fields[i].(string)
+		values = append(values, value)
+		if value != "" {
+			indexKey, _ := credentialIndexKey(credType, value)
+			indexKeys = append(indexKeys, indexKey)
+		}
+	}
+	return values, indexKeys, nil
 }
 
-// Сохранение user_id в Redis по всем типам учетных данных
 func saveUserIdToAllCredTypes(login, email, phone, userId string) error {
-	ctx := context.Background()
-	
-	// Сохраняем по логину
-	key := fmt.Sprintf("auth:login:%s:user_id", login)
-	log.Printf("Сохраняем ID пользователя по логину: %s = %s", key, userId)
-	err := redisClient.Set(ctx, key, userId, 0).Err()
+	return saveUserIdToRedis("login", login, userId)
+}
+
+func getUserIdFromRedis(credType, identifier string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return resolveAccountId(ctx, credType, identifier)
+}
+
+// verifiedField - поле хеша аккаунта с признаком подтверждения email или телефона
+func verifiedField(credType string) (string, error) {
+	switch credType {
+	case "email", "phone":
+		return credType + "_verified", nil
+	default:
+		return "", fmt.Errorf("%w: %s", errInvalidCredType, credType)
+	}
+}
+
+func setCredentialVerified(userId, credType string, verified bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	field, err := verifiedField(credType)
 	if err != nil {
-		log.Printf("Ошибка сохранения ID пользователя по логину: %v", err)
 		return err
 	}
-	
-	// Сохраняем по email
-	key = fmt.Sprintf("auth:email:%s:user_id", email)
-	log.Printf("Сохраняем ID пользователя по email: %s = %s", key, userId)
-	err = redisClient.Set(ctx, key, userId, 0).Err()
+
+	exists, err := redisClient.Exists(ctx, accountKey(userId)).Result()
 	if err != nil {
-		log.Printf("Ошибка сохранения ID пользователя по email: %v", err)
 		return err
 	}
-	
-	// Сохраняем по телефону
-	key = fmt.Sprintf("auth:phone:%s:user_id", phone)
-	log.Printf("Сохраняем ID пользователя по телефону: %s = %s", key, userId)
-	err = redisClient.Set(ctx, key, userId, 0).Err()
+	if exists == 0 {
+		return errAccountNotFound
+	}
+
+	value := "0"
+	if verified {
+		value = "1"
+	}
+	return redisClient.HSet(ctx, accountKey(userId), field, value).Err()
+}
+
+// isCredentialVerified сообщает, подтвержден ли email или телефон, по которому выполняется вход.
+// Логин подтверждения не требует
+func isCredentialVerified(credType, identifier string) (bool, error) {
+	if credType == "login" {
+		return true, nil
+	}
+
+	field, err := verifiedField(credType)
 	if err != nil {
-		log.Printf("Ошибка сохранения ID пользователя по телефону: %v", err)
-		return err
+		return false, err
 	}
-	
-	log.Printf("ID пользователя успешно сохранен по всем типам учетных данных")
-	return nil
+
+	value, err := getAccountField(credType, identifier, field)
+	if errors.Is(err, redis.Nil) || errors.Is(err, errAccountNotFound) {
+		return false, nil
+	}
+	return value == "1", err
 }
 
-// Получение user_id из Redis
-func getUserIdFromRedis(credType, identifier string) (string, error) {
-	ctx := context.Background()
-	
-	var redisKey string
-	
-	switch credType {
-	case "email":
-		redisKey = "auth:email:" + identifier + ":user_id"
-		log.Printf("Ищем ID пользователя по email: %s", redisKey)
-	case "login":
-		redisKey = "auth:login:" + identifier + ":user_id"
-		log.Printf("Ищем ID пользователя по логину: %s", redisKey)
-	case "phone":
-		redisKey = "auth:phone:" + identifier + ":user_id"
-		log.Printf("Ищем ID пользователя по телефону: %s", redisKey)
-	default:
-		return "", fmt.Errorf("invalid credential type: %s", credType)
+// credentialUpdate - новые учетные данные аккаунта; пустые поля не меняются
+type credentialUpdate struct {
+	Login         string
+	Email         string
+	Phone         string
+	PasswordHash  string
+	EmailVerified *bool
+	PhoneVerified *bool
+	// если задано, изменение применяется, только пока аккаунт хранит эти значения
+	Expected *credentialExpectation
+}
+
+// credentialExpectation - значения, которые должны быть в аккаунте; пустые поля не проверяются
+type credentialExpectation struct {
+	Login        string
+	Email        string
+	Phone        string
+	PasswordHash string
+}
+
+// updateAccountCredentials атомарно меняет логин, email, телефон и хеш пароля аккаунта.
+// При смене пароля все сессии пользователя отзываются, а прежний хеш возвращается,
+// чтобы user-service мог откатить изменение
+func updateAccountCredentials(userId string, update credentialUpdate) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for attempt := 0; attempt < credentialScriptAttempts; attempt++ {
+		result, previousHash, err := runUpdateCredentials(ctx, userId, update)
+		if err != nil {
+			return "", err
+		}
+		switch result {
+		case -1:
+			return "", errAccountNotFound
+		case 0:
+			return "", errCredentialTaken
+		case -3:
+			// без expected хеш сверялся только с прочитанным перед скриптом
+			if update.Expected != nil {
+				return "", errCredentialsMismatch
+			}
+		case 1:
+			if update.PasswordHash != "" {
+				return previousHash, revokeAllUserTokens(userId)
+			}
+			return "", nil
+		}
 	}
-	
-	userId, err := redisClient.Get(ctx, redisKey).Result()
+	return "", errCredentialsChanged
+}
+
+// runUpdateCredentials читает текущие учетные данные и передает скрипту индексы и новых, и заменяемых значений.
+// При смене пароля возвращает хеш, который скрипт заменил
+func runUpdateCredentials(ctx context.Context, userId string, update credentialUpdate) (int, string, error) {
+	current, _, err := currentCredentials(ctx, userId)
 	if err != nil {
-		log.Printf("ID пользователя не найден для %s %s: %v", credType, identifier, err)
-		return "", err
+		return 0, "", err
+	}
+
+	previousHash := ""
+	if update.PasswordHash != "" {
+		previousHash, err = redisClient.HGet(ctx, accountKey(userId), "password_hash").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return 0, "", err
+		}
+	}
+
+	// логин, email и телефон сверяются здесь, а скрипт проверяет, что они не изменились после чтения
+	expectedHash := ""
+	if expected := update.Expected; expected != nil {
+		expectedValues := []string{expected.Login, expected.Email, expected.Phone}
+		for i := range credentialTypes {
+			if expectedValues[i] != "" && expectedValues[i] != current[i].(string) {
+				return -3, "", nil
+			}
+		}
+		expectedHash = expected.PasswordHash
+	}
+	if expectedHash == "" {
+		expectedHash = previousHash
 	}
-	
-	log.Printf("Найден ID пользователя: %s = %s", redisKey, userId)
-	return userId, nil
-}
\ No newline at end of file
+
+	keys := []string{accountKey(userId)}
+	var pairs []any
+	var replacedKeys []string
+	values := map[string]string{"login": update.Login, "email": update.Email, "phone": update.Phone}
+	for i, credType := range credentialTypes {
+		if values[credType] == "" {
+			continue
+		}
+		indexKey, _ := credentialIndexKey(credType, values[credType])
+		keys = append(keys, indexKey)
+		pairs = append(pairs, credType, values[credType])
+
+		if old := current[i].(string); old != "" && old != values[credType] {
+			oldKey, _ := credentialIndexKey(credType, old)
+			replacedKeys = append(replacedKeys, oldKey)
+		}
+	}
+	keys = append(keys, replacedKeys...)
+
+	args := []any{userId, update.PasswordHash, verifiedArg(update.EmailVerified), verifiedArg(update.PhoneVerified)}
+	args = append(args, current...)
+	args = append(args, expectedHash, len(pairs)/2)
+	args = append(args, pairs...)
+
+	result, err := updateCredentialsScript.Run(ctx, redisClient, keys, args...).Int()
+	return result, previousHash, err
+}
+
+func verifiedArg(verified *bool) string {
+	switch {
+	case verified == nil:
+		return ""
+	case *verified:
+		return "1"
+	default:
+		return "0"
+	}
+}
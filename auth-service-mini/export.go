@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Поля хеша аккаунта, которые не отдаются даже владельцу: это секреты, а не данные о человеке
+var exportHiddenFields = []string{"password_hash", "totp_secret", "totp_pending_secret", "totp_last_step"}
+
+// exportAccount собирает все, что auth-service хранит об аккаунте: учетные данные, роли,
+// активные сессии и журнал входов
+func exportAccount(userId string) (gin.H, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fields, err := redisClient.HGetAll(ctx, accountKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errAccountNotFound
+	}
+
+	account := gin.H{}
+	for field, value := range fields {
+		if !slices.Contains(exportHiddenFields, field) {
+			account[field] = value
+		}
+	}
+
+	roles, err := getAccountRoles(userId)
+	if err != nil {
+		return nil, err
+	}
+	account["roles"] = roles
+
+	sessions, err := listSessions(userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	events, _, err := accountAuditEvents(userId, "", auditUserStreamLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{"account": account, "sessions": sessions, "audit": events}, nil
+}
+
+// exportAccountHandler вызывается user-service при выгрузке персональных данных
+func exportAccountHandler(c *gin.Context) {
+	var request struct {
+		UserId string `json:"user_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	export, err := exportAccount(request.UserId)
+	if errors.Is(err, errAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка выгрузки аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auth-kit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const internalServiceContextKey = "internal_service"
+
+// ответ на запрос с Idempotency-Key хранится сутки: этого хватает на все повторы outbox
+const idempotencyTTL = 24 * time.Hour
+
+func internalNonceKey(service, nonce string) string {
+	return "auth:internal:nonce:" + service + ":" + nonce
+}
+
+// internalMiddleware пропускает только запросы, подписанные ключом одного из сервисов из INTERNAL_KEYS.
+// Nonce запоминается на время допустимого расхождения часов, поэтому перехваченный запрос нельзя повторить
+func internalMiddleware(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	signed, err := authkit.VerifyRequest(c.Request, body, appConfig.InternalKeys)
+	if err != nil {
+		log.Printf("Отклонен служебный запрос %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Запрос не авторизован"})
+		c.Abort()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fresh, err := redisClient.SetNX(ctx, internalNonceKey(signed.Service, signed.Nonce), 1, 2*authkit.MaxClockSkew).Result()
+	if err != nil {
+		log.Printf("Не удалось проверить nonce служебного запроса: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		c.Abort()
+		return
+	}
+	if !fresh {
+		log.Printf("Повтор служебного запроса от %s", signed.Service)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Запрос не авторизован"})
+		c.Abort()
+		return
+	}
+
+	c.Set(internalServiceContextKey, signed.Service)
+	c.Next()
+}
+
+func idempotencyRecordKey(service, key string) string {
+	return "auth:idempotency:" + service + ":" + key
+}
+
+// responseRecorder копирует тело ответа, чтобы его можно было сохранить
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// idempotencyMiddleware отвечает на повтор служебного запроса с тем же Idempotency-Key
+// сохраненным ответом, не выполняя его заново. Запросы без ключа проходят как есть
+func idempotencyMiddleware(c *gin.Context) {
+	key := c.GetHeader(authkit.HeaderIdempotencyKey)
+	if key == "" {
+		c.Next()
+		return
+	}
+	recordKey := idempotencyRecordKey(c.GetString(internalServiceContextKey), key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	stored, err := redisClient.HMGet(ctx, recordKey, "status", "body").Result()
+	cancel()
+	if err != nil {
+		log.Printf("Не удалось проверить ключ идемпотентности: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		c.Abort()
+		return
+	}
+	if statusValue, ok := stored[0].(string); ok {
+		status, _ := strconv.Atoi(statusValue)
+		body, _ := stored[1].(string)
+		c.Data(status, "application/json; charset=utf-8", []byte(body))
+		c.Abort()
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+
+	// ошибки сервера не запоминаются, чтобы повтор выполнился заново
+	if recorder.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, recordKey, "status", recorder.Status(), "body", recorder.body.String())
+		pipe.Expire(ctx, recordKey, idempotencyTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Не удалось сохранить ответ по ключу идемпотентности: %v", err)
+	}
+}
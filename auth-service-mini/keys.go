@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	signingKeysKey        = "auth:jwks:keys"
+	activeSigningKeyKey   = "auth:jwks:active"
+	keyRotationLockKey    = "auth:jwks:rotation_lock"
+	signingKeyBits        = 2048
+	keyRotationCheckEvery = time.Minute
+	// минимальный интервал между перечитываниями ключей из-за незнакомого kid
+	keyReloadCooldown = 30 * time.Second
+)
+
+var errUnknownSigningKey = errors.New("неизвестный ключ подписи")
+
+// storedSigningKey - ключ подписи в том виде, в котором он лежит в Redis.
+// ExpiresAt - момент, после которого ключ не нужен даже для проверки:
+// время вывода из оборота плюс время жизни access токена.
+type storedSigningKey struct {
+	PrivateKey string    `json:"private_key"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+}
+
+type signingKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+	CreatedAt  time.Time
+}
+
+// keyring - локальный кэш ключей из Redis, общий для всех инстансов сервиса
+type keyring struct {
+	mu       sync.RWMutex
+	activeID string
+	keys     map[string]signingKey
+	loadedAt time.Time
+
+	// reloadMu не дает одновременным запросам с незнакомым kid перечитывать ключи параллельно
+	reloadMu sync.Mutex
+}
+
+var signingKeys = &keyring{keys: map[string]signingKey{}}
+
+func (k *keyring) active() (signingKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[k.activeID]
+	if !ok {
+		return signingKey{}, errors.New("нет активного ключа подписи")
+	}
+	return key, nil
+}
+
+func (k *keyring) publicKey(kid string) (*rsa.PublicKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if ok {
+		return &key.PrivateKey.PublicKey, nil
+	}
+
+	// ключ мог появиться после ротации на другом инстансе, но случайные kid
+	// не должны превращаться в чтение Redis на каждый запрос
+	k.reloadMu.Lock()
+	k.mu.RLock()
+	key, ok = k.keys[kid]
+	loadedAt := k.loadedAt
+	k.mu.RUnlock()
+	if ok {
+		// ключ успел загрузить параллельный запрос
+		k.reloadMu.Unlock()
+		return &key.PrivateKey.PublicKey, nil
+	}
+	if time.Since(loadedAt) < keyReloadCooldown {
+		k.reloadMu.Unlock()
+		return nil, errUnknownSigningKey
+	}
+	err := k.reload()
+	k.reloadMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok = k.keys[kid]
+	if !ok {
+		return nil, errUnknownSigningKey
+	}
+	return &key.PrivateKey.PublicKey, nil
+}
+
+func (k *keyring) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	activeID, err := redisClient.Get(ctx, activeSigningKeyKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	raw, err := redisClient.HGetAll(ctx, signingKeysKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]signingKey, len(raw))
+	for kid, value := range raw {
+		var stored storedSigningKey
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			log.Printf("Не удалось разобрать ключ подписи %s: %v", kid, err)
+			continue
+		}
+
+		block, _ := pem.Decode([]byte(stored.PrivateKey))
+		if block == nil {
+			log.Printf("Ключ подписи %s поврежден", kid)
+			continue
+		}
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			log.Printf("Не удалось разобрать ключ подписи %s: %v", kid, err)
+			continue
+		}
+
+		keys[kid] = signingKey{ID: kid, PrivateKey: privateKey, CreatedAt: stored.CreatedAt}
+	}
+
+	k.mu.Lock()
+	k.activeID = activeID
+	k.keys = keys
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+
+	return nil
+}
+
+func (k *keyring) jwks() []gin.H {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	result := make([]gin.H, 0, len(k.keys))
+	for kid, key := range k.keys {
+		publicKey := key.PrivateKey.PublicKey
+		result = append(result, gin.H{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		})
+	}
+	return result
+}
+
+// rotateSigningKeyIfNeeded выпускает новый ключ, если активный старше интервала ротации,
+// и удаляет ключи, которыми уже не может быть подписан ни один живой токен
+func rotateSigningKeyIfNeeded() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// ротацию выполняет только один инстанс
+	locked, err := redisClient.SetNX(ctx, keyRotationLockKey, 1, 30*time.Second).Result()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return signingKeys.reload()
+	}
+	defer redisClient.Del(ctx, keyRotationLockKey)
+
+	if err := signingKeys.reload(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	active, err := signingKeys.active()
+	if err != nil || now.Sub(active.CreatedAt) >= appConfig.KeyRotationInterval {
+		if err := createSigningKey(ctx, active.ID, now); err != nil {
+			return err
+		}
+	}
+
+	if err := pruneSigningKeys(ctx, now); err != nil {
+		return err
+	}
+
+	return signingKeys.reload()
+}
+
+func createSigningKey(ctx context.Context, previousId string, now time.Time) error {
+	privateKey, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return err
+	}
+
+	kid, err := newTokenID()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(storedSigningKey{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		})),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	var retired []byte
+	if previousId != "" {
+		raw, err := redisClient.HGet(ctx, signingKeysKey, previousId).Result()
+		if err == nil {
+			var previous storedSigningKey
+			if err := json.Unmarshal([]byte(raw), &previous); err == nil {
+				previous.ExpiresAt = now.Add(appConfig.AccessTokenTTL)
+				retired, _ = json.Marshal(previous)
+			}
+		}
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, signingKeysKey, kid, encoded)
+		if retired != nil {
+			pipe.HSet(ctx, signingKeysKey, previousId, retired)
+		}
+		pipe.Set(ctx, activeSigningKeyKey, kid, 0)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Выпущен новый ключ подписи %s", kid)
+	return nil
+}
+
+func pruneSigningKeys(ctx context.Context, now time.Time) error {
+	raw, err := redisClient.HGetAll(ctx, signingKeysKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for kid, value := range raw {
+		var stored storedSigningKey
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			continue
+		}
+		if !stored.ExpiresAt.IsZero() && now.After(stored.ExpiresAt) {
+			if err := redisClient.HDel(ctx, signingKeysKey, kid).Err(); err != nil {
+				return err
+			}
+			log.Printf("Ключ подписи %s удален", kid)
+		}
+	}
+
+	return nil
+}
+
+// initSigningKeys гарантирует наличие активного ключа и запускает плановую ротацию
+func initSigningKeys() error {
+	if err := rotateSigningKeyIfNeeded(); err != nil {
+		return fmt.Errorf("не удалось подготовить ключи подписи: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(keyRotationCheckEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := rotateSigningKeyIfNeeded(); err != nil {
+				log.Printf("Ошибка ротации ключей подписи: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func getJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": signingKeys.jwks()})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Неудачные попытки входа считаются в скользящем окне отдельно по аккаунту
+// и по IP клиента. Логин, email и телефон одного аккаунта делят один счетчик. После превышения лимита субъект блокируется, и каждая
+// следующая блокировка в течение lockoutLevelTTL вдвое длиннее предыдущей.
+
+const lockoutLevelTTL = 24 * time.Hour
+
+func loginFailuresKey(subject string) string {
+	return "auth:login:failures:" + subject
+}
+
+func loginLockKey(subject string) string {
+	return "auth:login:lock:" + subject
+}
+
+func loginLockLevelKey(subject string) string {
+	return "auth:login:lock_level:" + subject
+}
+
+func credentialSubject(credType, identifier string) string {
+	return "cred:" + credType + ":" + identifier
+}
+
+func accountSubject(userId string) string {
+	return "account:" + userId
+}
+
+// loginSubject - счетчик попыток для учетных данных: аккаунт, если он найден,
+// иначе сами учетные данные, чтобы перебор несуществующих тоже ограничивался
+func loginSubject(credType, identifier string) string {
+	userId, err := getUserIdFromRedis(credType, identifier)
+	if err != nil || userId == "" {
+		return credentialSubject(credType, identifier)
+	}
+	return accountSubject(userId)
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// KEYS[1] - окно попыток, KEYS[2] - блокировка, KEYS[3] - уровень блокировки
+// ARGV: текущее время (мс), окно (мс), лимит, базовая блокировка (мс), максимальная (мс),
+// TTL уровня (мс), уникальный идентификатор попытки
+// Возвращает длительность наложенной блокировки в мс или 0
+var registerLoginFailureScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+redis.call('ZADD', KEYS[1], now, ARGV[7])
+redis.call('PEXPIRE', KEYS[1], window)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[3]) then
+	return 0
+end
+local level = redis.call('INCR', KEYS[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[6])
+local duration = tonumber(ARGV[4]) * 2 ^ (level - 1)
+if duration > tonumber(ARGV[5]) then
+	duration = tonumber(ARGV[5])
+end
+redis.call('SET', KEYS[2], 1, 'PX', math.floor(duration))
+redis.call('DEL', KEYS[1])
+return math.floor(duration)
+`)
+
+// loginLockedFor возвращает, сколько еще действует самая долгая из блокировок субъектов
+func loginLockedFor(subjects ...string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var longest time.Duration
+	for _, subject := range subjects {
+		ttl, err := redisClient.PTTL(ctx, loginLockKey(subject)).Result()
+		if err != nil {
+			return 0, err
+		}
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest, nil
+}
+
+// registerLoginFailure учитывает неудачную попытку и возвращает длительность
+// блокировки, если попытка превысила лимит
+func registerLoginFailure(subject string, limit int) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	attemptId, err := newTokenID()
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{loginFailuresKey(subject), loginLockKey(subject), loginLockLevelKey(subject)}
+	lockedMs, err := registerLoginFailureScript.Run(ctx, redisClient, keys,
+		time.Now().UnixMilli(),
+		appConfig.LoginFailureWindow.Milliseconds(),
+		limit,
+		appConfig.LoginLockoutBase.Milliseconds(),
+		appConfig.LoginLockoutMax.Milliseconds(),
+		lockoutLevelTTL.Milliseconds(),
+		attemptId,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(lockedMs) * time.Millisecond, nil
+}
+
+// resetLoginFailures сбрасывает счетчик после успешного входа,
+// уровень блокировки остается до истечения lockoutLevelTTL
+func resetLoginFailures(subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return redisClient.Del(ctx, loginFailuresKey(subject)).Err()
+}
+
+func unlockLogin(subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return redisClient.Del(ctx,
+		loginFailuresKey(subject),
+		loginLockKey(subject),
+		loginLockLevelKey(subject),
+	).Err()
+}
+
+func respondLoginLocked(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Слишком много попыток входа, попробуйте позже",
+		"retry_after": seconds,
+	})
+}
+
+// unlockLoginHandler снимает блокировку входа с аккаунта и/или IP
+func unlockLoginHandler(c *gin.Context) {
+	var request struct {
+		UserId string `json:"user_id"`
+		Creds  string `json:"creds"`
+		Value  string `json:"value"`
+		IP     string `json:"ip"`
+	}
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil || (request.UserId == "" && request.Value == "" && request.IP == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нужно указать аккаунт, учетные данные или IP"})
+		return
+	}
+
+	var subjects []string
+	if request.UserId != "" {
+		subjects = append(subjects, accountSubject(request.UserId))
+	}
+	if request.Value != "" {
+		subjects = append(subjects, loginSubject(request.Creds, request.Value), credentialSubject(request.Creds, request.Value))
+	}
+	if request.IP != "" {
+		subjects = append(subjects, ipSubject(request.IP))
+	}
+
+	for _, subject := range subjects {
+		err = unlockLogin(subject)
+		if err != nil {
+			log.Printf("Ошибка снятия блокировки входа: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+			return
+		}
+	}
+
+	log.Printf("Блокировка входа снята: %d субъектов", len(subjects))
+	userId := request.UserId
+	if userId == "" {
+		userId = auditUserId(request.Creds, request.Value)
+	}
+	recordAuthEvent(c, authEvent{
+		Type: auditLoginUnlocked, UserID: userId,
+		CredType: request.Creds, Identifier: request.Value, Detail: request.IP,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Блокировка снята"})
+}
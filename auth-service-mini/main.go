@@ -1,12 +1,15 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	migrateAccounts := flag.Bool("migrate-accounts", false, "перенести аккаунты из старой раскладки ключей и выйти")
+	flag.Parse()
 
 	err := LoadEnv()
 	if err != nil {
@@ -14,20 +17,91 @@ func main() {
 		return
 	}
 
-	initDatabase()
+	err = LoadConfig()
+	if err != nil {
+		log.Println("Не удалось загрузить конфигурацию", err)
+		return
+	}
+
+	err = initDatabase()
+	if err != nil {
+		log.Println("Не удалось подключиться к редису", err)
+		return
+	}
+
+	if *migrateAccounts {
+		err = migrateLegacyAccounts()
+		if err != nil {
+			log.Println("Ошибка миграции аккаунтов:", err)
+		}
+		return
+	}
+
+	err = initSigningKeys()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if len(appConfig.InternalKeys) == 0 {
+		log.Println("INTERNAL_KEYS не задан: служебные ручки будут отклонять все запросы")
+	}
+
+	if err := initSenders(); err != nil {
+		log.Println("Не удалось настроить отправку кодов", err)
+		return
+	}
 
 	router := gin.Default()
+	// без доверенных прокси ClientIP - адрес соединения, и X-Forwarded-For не подменить;
+	// user-service передает адрес своего клиента через подписанный /internalLogin
+	if err := router.SetTrustedProxies(appConfig.TrustedProxies); err != nil {
+		log.Println("Некорректное значение TRUSTED_PROXIES", err)
+		return
+	}
 
-	router.POST("/userReg", saveUserRegToRedis)
+	router.GET("/.well-known/jwks.json", getJWKS)
 	router.POST("/login", login)
+	router.POST("/login/code", requestLoginCode)
+	router.POST("/login/code/verify", loginWithCode)
+	router.POST("/login/2fa", loginSecondFactor)
+	router.POST("/refresh", refresh)
 	router.POST("/verify", verifyToken)
-	router.POST("/saveAnketaId", saveAnketaId)
-	router.POST("/saveAnketaIdToAll", saveAnketaIdToAll)
-	router.POST("/getAnketaId", getAnketaId)
-	router.POST("/getAllUserCreds", getAllUserCredsHandler)
-	router.POST("/saveUserId", saveUserId)
-	router.POST("/saveUserIdToAll", saveUserIdToAll)
-	router.POST("/getUserId", getUserId)
+	router.GET("/revocations", revocationFeedHandler)
+	router.POST("/password/forgot", forgotPassword)
+	router.POST("/password/reset", resetPasswordHandler)
+	router.POST("/logout", authMiddleware, logout)
+	router.POST("/logout-all", authMiddleware, logoutAll)
+	router.GET("/sessions", authMiddleware, listSessionsHandler)
+	router.DELETE("/sessions/:id", authMiddleware, revokeSessionHandler)
+	router.POST("/sessions/revoke-others", authMiddleware, revokeOtherSessionsHandler)
+	router.POST("/2fa/enroll", authMiddleware, enrollTOTP)
+	router.POST("/2fa/confirm", authMiddleware, confirmTOTP)
+	router.POST("/2fa/disable", authMiddleware, disableTOTP)
+	router.GET("/account/audit", authMiddleware, getOwnAudit)
+
+	// служебные ручки доступны только другим сервисам по подписанным запросам
+	internal := router.Group("/", internalMiddleware, idempotencyMiddleware)
+	internal.POST("/internalLogin", internalLogin)
+	internal.POST("/userReg", saveUserRegToRedis)
+	internal.POST("/saveAnketaId", saveAnketaId)
+	internal.POST("/saveAnketaIdToAll", saveAnketaIdToAll)
+	internal.POST("/getAnketaId", getAnketaId)
+	internal.POST("/getAllUserCreds", getAllUserCredsHandler)
+	internal.POST("/saveUserId", saveUserId)
+	internal.POST("/saveUserIdToAll", saveUserIdToAll)
+	internal.POST("/getUserId", getUserId)
+	internal.POST("/setCredentialVerified", setCredentialVerifiedHandler)
+	internal.POST("/updateCredentials", updateCredentialsHandler)
+	internal.POST("/activateAccount", activateAccountHandler)
+	internal.POST("/setAccountDisabled", setAccountDisabledHandler)
+	internal.POST("/deleteAccount", deleteAccountHandler)
+	internal.POST("/exportAccount", exportAccountHandler)
+
+	router.POST("/admin/unlock-login", authMiddleware, adminMiddleware, unlockLoginHandler)
+	router.GET("/admin/audit/:user_id", authMiddleware, adminMiddleware, getAccountAudit)
+	router.POST("/admin/roles", authMiddleware, adminMiddleware, setRolesHandler)
+	router.POST("/admin/bootstrap", bootstrapAdminHandler)
 
 	err = router.Run("127.0.0.1:8001")
 	if err != nil {
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth-kit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mfaTokenTTL          = 5 * time.Minute
+	mfaMaxAttempts       = 5
+	recoveryCodesCount   = 10
+	recoveryCodeByteSize = 5
+)
+
+var (
+	errMFAUnavailable  = errors.New("2FA не настроена на сервере")
+	errMFAChallengeBad = errors.New("mfa_token невалиден или истек")
+)
+
+func mfaChallengeKey(token string) string {
+	return "auth:mfa:challenge:" + token
+}
+
+func recoveryCodesKey(userId string) string {
+	return "auth:user:" + userId + ":recovery_codes"
+}
+
+// Сохраняет шаг последнего принятого TOTP кода и отклоняет коды того же или более раннего шага
+var acceptTOTPStepScript = redis.NewScript(`
+local last = tonumber(redis.call('HGET', KEYS[1], 'totp_last_step') or '-1')
+if tonumber(ARGV[1]) <= last then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'totp_last_step', ARGV[1])
+return 1
+`)
+
+// Секреты TOTP хранятся в хеше аккаунта зашифрованными AES-256-GCM: base64(nonce || ciphertext)
+
+func encryptSecret(plain string) (string, error) {
+	gcm, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(encoded string) (string, error) {
+	gcm, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("поврежденный секрет 2FA")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func mfaCipher() (cipher.AEAD, error) {
+	if len(appConfig.MFAEncryptionKey) == 0 {
+		return nil, errMFAUnavailable
+	}
+
+	block, err := aes.NewCipher(appConfig.MFAEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func isTOTPEnabled(userId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	enabled, err := redisClient.HGet(ctx, accountKey(userId), "totp_enabled").Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return enabled == "1", err
+}
+
+// createMFAChallenge запоминает, кто прошел первый шаг входа, и возвращает mfa_token для второго
+func createMFAChallenge(userId, credType, identifier string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, mfaChallengeKey(token),
+			"user_id", userId,
+			"cred_type", credType,
+			"identifier", identifier,
+			"attempts", 0,
+		)
+		pipe.Expire(ctx, mfaChallengeKey(token), mfaTokenTTL)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// verifySecondFactor принимает TOTP код или один из кодов восстановления
+func verifySecondFactor(userId, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	code = normalizeSecondFactorCode(code)
+
+	encrypted, err := redisClient.HGet(ctx, accountKey(userId), "totp_secret").Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	secret, err := decryptSecret(encrypted)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := validateTOTP(secret, code, time.Now()); ok {
+		accepted, err := acceptTOTPStepScript.Run(ctx, redisClient, []string{accountKey(userId)}, step).Int()
+		return accepted == 1, err
+	}
+
+	// код восстановления одноразовый: удаляется при использовании
+	removed, err := redisClient.SRem(ctx, recoveryCodesKey(userId), authkit.HashCode(code)).Result()
+	if err != nil {
+		return false, err
+	}
+	if removed == 1 {
+		log.Printf("Использован код восстановления 2FA")
+	}
+	return removed == 1, nil
+}
+
+// normalizeSecondFactorCode убирает пробелы и дефисы, которые пользователи вводят по-разному
+func normalizeSecondFactorCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeByteSize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := hex.EncodeToString(raw)
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+	}
+	return codes, nil
+}
+
+func enrollTOTP(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	enabled, err := isTOTPEnabled(claims.Subject)
+	if err != nil {
+		log.Printf("Не удалось проверить статус 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "2FA уже включена"})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		log.Printf("Ошибка генерации секрета 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	encrypted, err := encryptSecret(secret)
+	if errors.Is(err, errMFAUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "2FA временно недоступна"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка шифрования секрета 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	login, err := redisClient.HGet(ctx, accountKey(claims.Subject), "login").Result()
+	if err != nil {
+		log.Printf("Не удалось прочитать аккаунт: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	// секрет становится рабочим только после подтверждения кодом из приложения
+	err = redisClient.HSet(ctx, accountKey(claims.Subject), "totp_pending_secret", encrypted).Err()
+	if err != nil {
+		log.Printf("Ошибка сохранения секрета 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	uri := totpProvisioningURI(appConfig.MFAIssuer, login, secret)
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": uri,
+		"qr_payload":       uri,
+	})
+}
+
+func confirmTOTP(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	encrypted, err := redisClient.HGet(ctx, accountKey(claims.Subject), "totp_pending_secret").Result()
+	if errors.Is(err, redis.Nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сначала начните подключение 2FA"})
+		return
+	}
+	if err != nil {
+		log.Printf("Не удалось прочитать секрет 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	secret, err := decryptSecret(encrypted)
+	if err != nil {
+		log.Printf("Не удалось расшифровать секрет 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	step, ok := validateTOTP(secret, strings.TrimSpace(request.Code), time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	recoveryCodes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Ошибка генерации кодов восстановления: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	hashes := make([]any, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = authkit.HashCode(normalizeSecondFactorCode(code))
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, accountKey(claims.Subject),
+			"totp_secret", encrypted,
+			"totp_enabled", "1",
+			"totp_last_step", step,
+		)
+		pipe.HDel(ctx, accountKey(claims.Subject), "totp_pending_secret")
+		pipe.Del(ctx, recoveryCodesKey(claims.Subject))
+		pipe.SAdd(ctx, recoveryCodesKey(claims.Subject), hashes...)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Ошибка включения 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditMFAEnabled, UserID: claims.Subject, SessionID: claims.SessionID})
+
+	// коды восстановления показываются один раз, в хранилище лежат только их хеши
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "2FA включена",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+func disableTOTP(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	ok, err := verifySecondFactor(claims.Subject, request.Code)
+	if err != nil {
+		log.Printf("Ошибка проверки второго фактора: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, accountKey(claims.Subject), "totp_secret", "totp_pending_secret", "totp_enabled", "totp_last_step")
+		pipe.Del(ctx, recoveryCodesKey(claims.Subject))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Ошибка отключения 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditMFADisabled, UserID: claims.Subject, SessionID: claims.SessionID})
+	c.JSON(http.StatusOK, gin.H{"message": "2FA отключена"})
+}
+
+// loginSecondFactor - второй шаг входа: обмен mfa_token и кода на токены
+func loginSecondFactor(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var request struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	challenge, err := redisClient.HGetAll(ctx, mfaChallengeKey(request.MFAToken)).Result()
+	if err != nil {
+		log.Printf("Не удалось прочитать mfa_token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if challenge["user_id"] == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMFAChallengeBad.Error()})
+		return
+	}
+
+	credSubject := accountSubject(challenge["user_id"])
+	retryAfter, err := loginLockedFor(credSubject)
+	if err != nil {
+		log.Printf("Не удалось проверить блокировку входа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if retryAfter > 0 {
+		respondLoginLocked(c, retryAfter)
+		return
+	}
+
+	attempts, err := redisClient.HIncrBy(ctx, mfaChallengeKey(request.MFAToken), "attempts", 1).Result()
+	if err != nil {
+		log.Printf("Не удалось учесть попытку 2FA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if attempts > mfaMaxAttempts {
+		redisClient.Del(ctx, mfaChallengeKey(request.MFAToken))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMFAChallengeBad.Error()})
+		return
+	}
+
+	ok, err := verifySecondFactor(challenge["user_id"], request.Code)
+	if err != nil {
+		log.Printf("Ошибка проверки второго фактора: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if !ok {
+		recordAuthEvent(c, authEvent{
+			Type: auditLoginFailure, UserID: challenge["user_id"], CredType: challenge["cred_type"],
+			Identifier: challenge["identifier"], Detail: "bad_mfa_code",
+		})
+
+		// неверные коды копятся в том же счетчике, что и неверные пароли
+		lock, err := registerLoginFailure(credSubject, appConfig.LoginMaxFailures)
+		if err != nil {
+			log.Printf("Не удалось учесть неудачную попытку входа: %v", err)
+		}
+		if lock > 0 {
+			redisClient.Del(ctx, mfaChallengeKey(request.MFAToken))
+			respondLoginLocked(c, lock)
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	// mfa_token одноразовый: при гонке двух запросов токены получит только один
+	deleted, err := redisClient.Del(ctx, mfaChallengeKey(request.MFAToken)).Result()
+	if err != nil || deleted == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMFAChallengeBad.Error()})
+		return
+	}
+
+	err = resetLoginFailures(credSubject)
+	if err != nil {
+		log.Printf("Не удалось сбросить счетчик попыток входа: %v", err)
+	}
+
+	issueLoginTokens(c, challenge["user_id"], challenge["cred_type"], challenge["identifier"])
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// legacyCredential - учетные данные в старой раскладке auth:{тип}:{значение}
+type legacyCredential struct {
+	CredType     string
+	Identifier   string
+	PasswordHash string
+	UserId       string
+	AnketaId     string
+}
+
+func (l legacyCredential) keys() []string {
+	base := "auth:" + l.CredType + ":" + l.Identifier
+	return []string{base, base + ":user_id", base + ":anketa_id"}
+}
+
+// migrateLegacyAccounts переносит старые ключи auth:{тип}:{значение} в хеши account:{user_id}.
+// Email и телефон связываются с логином через общий user_id, а если его нет -
+// через совпадение хеша пароля, но только когда такой хеш встречается один раз.
+func migrateLegacyAccounts() error {
+	ctx := context.Background()
+
+	byType := map[string][]legacyCredential{}
+	for _, credType := range credentialTypes {
+		creds, err := scanLegacyCredentials(ctx, credType)
+		if err != nil {
+			return err
+		}
+		byType[credType] = creds
+	}
+
+	hashCount := map[string]int{}
+	for _, cred := range byType["login"] {
+		hashCount[cred.PasswordHash]++
+	}
+
+	siblings := func(credType string, login legacyCredential) *legacyCredential {
+		for i, cred := range byType[credType] {
+			if cred.UserId != "" && cred.UserId == login.UserId {
+				return &byType[credType][i]
+			}
+		}
+		if hashCount[login.PasswordHash] != 1 {
+			return nil
+		}
+		for i, cred := range byType[credType] {
+			if cred.UserId == "" && cred.PasswordHash == login.PasswordHash {
+				return &byType[credType][i]
+			}
+		}
+		return nil
+	}
+
+	migrated, skipped := 0, 0
+	for _, login := range byType["login"] {
+		if login.UserId == "" {
+			log.Printf("Пропущен логин без user_id, его ключи оставлены как есть")
+			skipped++
+			continue
+		}
+
+		email := siblings("email", login)
+		phone := siblings("phone", login)
+
+		var emailValue, phoneValue string
+		legacyKeys := login.keys()
+		anketaId := login.AnketaId
+		if email != nil {
+			emailValue = email.Identifier
+			legacyKeys = append(legacyKeys, email.keys()...)
+			if anketaId == "" {
+				anketaId = email.AnketaId
+			}
+		}
+		if phone != nil {
+			phoneValue = phone.Identifier
+			legacyKeys = append(legacyKeys, phone.keys()...)
+			if anketaId == "" {
+				anketaId = phone.AnketaId
+			}
+		}
+
+		err := saveAccount(login.UserId, login.Identifier, emailValue, phoneValue, login.PasswordHash)
+		if err != nil {
+			log.Printf("Не удалось перенести аккаунт %s: %v", login.UserId, err)
+			skipped++
+			continue
+		}
+
+		if anketaId != "" {
+			err = redisClient.HSet(ctx, accountKey(login.UserId), "anketa_id", anketaId).Err()
+			if err != nil {
+				return err
+			}
+		}
+
+		err = redisClient.Del(ctx, legacyKeys...).Err()
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	log.Printf("Миграция завершена: перенесено %d, пропущено %d", migrated, skipped)
+	return nil
+}
+
+func scanLegacyCredentials(ctx context.Context, credType string) ([]legacyCredential, error) {
+	var result []legacyCredential
+
+	iter := redisClient.Scan(ctx, 0, "auth:"+credType+":*", 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		parts := strings.Split(key, ":")
+		// ключи :user_id и :anketa_id читаются вместе с основным
+		if len(parts) != 3 {
+			continue
+		}
+
+		cred := legacyCredential{CredType: credType, Identifier: parts[2]}
+
+		values, err := redisClient.MGet(ctx, key, key+":user_id", key+":anketa_id").Result()
+		if err != nil {
+			return nil, err
+		}
+		cred.PasswordHash, _ = values[0].(string)
+		cred.UserId, _ = values[1].(string)
+		cred.AnketaId, _ = values[2].(string)
+
+		if cred.PasswordHash == "" {
+			continue
+		}
+		result = append(result, cred)
+	}
+	if err := iter.Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"auth-kit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	passwordResetMaxAttempts = 5
+	passwordResetCooldown    = time.Minute
+)
+
+var errResetCodeInvalid = errors.New("код невалиден или истек")
+
+func passwordResetKey(userId string) string {
+	return "auth:password_reset:" + userId
+}
+
+func passwordResetCooldownKey(userId string) string {
+	return "auth:password_reset:" + userId + ":cooldown"
+}
+
+var setPasswordHashScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'password_hash', ARGV[1])
+return 1
+`)
+
+// Сверяет хеш кода и удаляет его при совпадении, чтобы код нельзя было использовать дважды.
+// После passwordResetMaxAttempts неверных попыток код сгорает.
+var consumeResetCodeScript = redis.NewScript(`
+local stored = redis.call('HGET', KEYS[1], 'code_hash')
+if not stored then
+	return 0
+end
+if stored ~= ARGV[1] then
+	if redis.call('HINCRBY', KEYS[1], 'attempts', 1) >= tonumber(ARGV[2]) then
+		redis.call('DEL', KEYS[1])
+	end
+	return 0
+end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// resetDestination выбирает, куда отправить код: на тот же email или телефон,
+// а для входа по логину - на email, если он указан, иначе на телефон
+func resetDestination(ctx context.Context, userId, credType string) (string, authkit.Sender, error) {
+	fields, err := redisClient.HMGet(ctx, accountKey(userId), "email", "phone").Result()
+	if err != nil {
+		return "", nil, err
+	}
+	email, _ := fields[0].(string)
+	phone, _ := fields[1].(string)
+
+	switch {
+	case credType == "email" && email != "":
+		return email, emailSender, nil
+	case credType == "phone" && phone != "":
+		return phone, smsSender, nil
+	case credType == "login" && email != "":
+		return email, emailSender, nil
+	case credType == "login" && phone != "":
+		return phone, smsSender, nil
+	}
+	return "", nil, errAccountNotFound
+}
+
+// requestPasswordReset генерирует одноразовый код и отправляет его владельцу аккаунта
+func requestPasswordReset(credType, identifier string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
+	if err != nil {
+		return err
+	}
+
+	destination, sender, err := resetDestination(ctx, userId, credType)
+	if err != nil {
+		return err
+	}
+
+	// не чаще одного кода в passwordResetCooldown на аккаунт
+	allowed, err := redisClient.SetNX(ctx, passwordResetCooldownKey(userId), 1, passwordResetCooldown).Result()
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return nil
+	}
+
+	code, err := authkit.NewNumericCode(6)
+	if err != nil {
+		return err
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, passwordResetKey(userId))
+		pipe.HSet(ctx, passwordResetKey(userId), "code_hash", authkit.HashCode(code), "attempts", 0)
+		pipe.Expire(ctx, passwordResetKey(userId), appConfig.PasswordResetTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Код для сброса пароля: %s. Он действует %d мин.", code, int(appConfig.PasswordResetTTL.Minutes()))
+	return sender.Send(ctx, destination, message)
+}
+
+// resetPassword проверяет код, заменяет хеш пароля и отзывает все сессии пользователя
+func resetPassword(credType, identifier, code, newPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
+	if errors.Is(err, errAccountNotFound) {
+		return errResetCodeInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	consumed, err := consumeResetCodeScript.Run(ctx, redisClient,
+		[]string{passwordResetKey(userId)}, authkit.HashCode(code), passwordResetMaxAttempts).Int()
+	if err != nil {
+		return err
+	}
+	if consumed != 1 {
+		return errResetCodeInvalid
+	}
+
+	passwordHash, err := passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// аккаунт могли удалить после проверки кода: HSET создал бы вместо него неполный хеш
+	saved, err := setPasswordHashScript.Run(ctx, redisClient, []string{accountKey(userId)}, passwordHash).Int()
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		return errResetCodeInvalid
+	}
+
+	err = revokeAllUserTokens(userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func forgotPassword(c *gin.Context) {
+	var request struct {
+		Creds string `json:"creds" binding:"required"`
+		Value string `json:"value" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	// ответ одинаковый независимо от существования аккаунта, чтобы нельзя было перебирать номера
+	err := requestPasswordReset(request.Creds, request.Value)
+	if err != nil && !errors.Is(err, errAccountNotFound) && !errors.Is(err, errInvalidCredType) {
+		log.Printf("Ошибка отправки кода сброса пароля: %v", err)
+	}
+
+	recordAuthEvent(c, authEvent{
+		Type: auditPasswordResetReq, UserID: auditUserId(request.Creds, request.Value),
+		CredType: request.Creds, Identifier: request.Value,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Если аккаунт существует, код отправлен"})
+}
+
+func resetPasswordHandler(c *gin.Context) {
+	var request struct {
+		Creds       string `json:"creds" binding:"required"`
+		Value       string `json:"value" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required,min=8"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	err := resetPassword(request.Creds, request.Value, request.Code, request.NewPassword)
+	if errors.Is(err, errResetCodeInvalid) || errors.Is(err, errInvalidCredType) {
+		recordAuthEvent(c, authEvent{
+			Type: auditPasswordResetFailed, UserID: auditUserId(request.Creds, request.Value),
+			CredType: request.Creds, Identifier: request.Value, Detail: "bad_code",
+		})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Код невалиден или истек"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сброса пароля: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	// после смены пароля старые неудачные попытки не должны держать аккаунт заблокированным
+	err = unlockLogin(loginSubject(request.Creds, request.Value))
+	if err != nil {
+		log.Printf("Не удалось снять блокировку входа: %v", err)
+	}
+
+	recordAuthEvent(c, authEvent{
+		Type: auditPasswordChanged, UserID: auditUserId(request.Creds, request.Value),
+		CredType: request.Creds, Identifier: request.Value, Detail: "reset",
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменен, войдите заново"})
+}
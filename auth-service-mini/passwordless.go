@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth-kit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Вход без пароля: на email или телефон уходит одноразовый код и, если задан
+// MAGIC_LINK_URL, ссылка с токеном. Код и ссылка хранятся хешами в одной записи
+// auth:passwordless:<user_id>, поэтому использование любого из них гасит оба.
+// Перебор кода и перебор ссылки считаются отдельно и не сжигают друг друга.
+
+const (
+	passwordlessMaxAttempts = 5
+	passwordlessCooldown    = time.Minute
+)
+
+var errPasswordlessInvalid = errors.New("код или ссылка невалидны или истекли")
+
+func passwordlessKey(userId string) string {
+	return "auth:passwordless:" + userId
+}
+
+func passwordlessCooldownKey(userId string) string {
+	return "auth:passwordless:" + userId + ":cooldown"
+}
+
+// KEYS[1] - запись входа, ARGV[1] - секрет (code или link), ARGV[2] - хеш предъявленного значения,
+// ARGV[3] - число попыток, ARGV[4] и ARGV[5] - учетные данные, для которых предъявлен код
+// (для ссылки пустые). У кода и ссылки свои счетчики: исчерпанные попытки гасят только
+// свой секрет, а запись удаляется, когда не осталось ни одного.
+// При совпадении возвращает {cred_type, identifier} и удаляет запись
+var consumePasswordlessScript = redis.NewScript(`
+local hashField = ARGV[1] .. '_hash'
+local stored = redis.call('HGET', KEYS[1], hashField)
+if not stored then
+	return false
+end
+local creds = redis.call('HMGET', KEYS[1], 'cred_type', 'identifier')
+local credsMatch = ARGV[4] == '' or (creds[1] == ARGV[4] and creds[2] == ARGV[5])
+if stored ~= ARGV[2] or not credsMatch then
+	if redis.call('HINCRBY', KEYS[1], ARGV[1] .. '_attempts', 1) >= tonumber(ARGV[3]) then
+		redis.call('HDEL', KEYS[1], hashField)
+		if redis.call('HEXISTS', KEYS[1], 'code_hash') == 0 and redis.call('HEXISTS', KEYS[1], 'link_hash') == 0 then
+			redis.call('DEL', KEYS[1])
+		end
+	end
+	return false
+end
+redis.call('DEL', KEYS[1])
+return creds
+`)
+
+// magicLinkUserId достает user_id из токена ссылки вида <user_id>.<секрет>
+func magicLinkUserId(token string) (string, bool) {
+	userId, secret, found := strings.Cut(token, ".")
+	if !found || userId == "" || secret == "" {
+		return "", false
+	}
+	return userId, true
+}
+
+// requestPasswordlessLogin отправляет код (и ссылку) для входа по email или телефону
+func requestPasswordlessLogin(credType, identifier string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if credType != "email" && credType != "phone" {
+		return errInvalidCredType
+	}
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
+	if err != nil {
+		return err
+	}
+
+	destination, sender, err := resetDestination(ctx, userId, credType)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := redisClient.SetNX(ctx, passwordlessCooldownKey(userId), 1, passwordlessCooldown).Result()
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return nil
+	}
+
+	code, err := authkit.NewNumericCode(6)
+	if err != nil {
+		return err
+	}
+	secret, err := newTokenID()
+	if err != nil {
+		return err
+	}
+	linkToken := userId + "." + secret
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, passwordlessKey(userId))
+		pipe.HSet(ctx, passwordlessKey(userId),
+			"code_hash", authkit.HashCode(code),
+			"link_hash", authkit.HashCode(linkToken),
+			"cred_type", credType,
+			"identifier", identifier,
+			"code_attempts", 0,
+			"link_attempts", 0,
+		)
+		pipe.Expire(ctx, passwordlessKey(userId), appConfig.PasswordlessTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	minutes := int(appConfig.PasswordlessTTL.Minutes())
+	message := fmt.Sprintf("Код для входа: %s. Он действует %d мин.", code, minutes)
+	if appConfig.MagicLinkURL != "" {
+		message += fmt.Sprintf(" Или перейдите по ссылке: %s?token=%s", appConfig.MagicLinkURL, url.QueryEscape(linkToken))
+	}
+	return sender.Send(ctx, destination, message)
+}
+
+// consumePasswordlessCode проверяет код для учетных данных и возвращает user_id
+func consumePasswordlessCode(credType, identifier, code string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, err := resolveAccountId(ctx, credType, identifier)
+	if errors.Is(err, errAccountNotFound) || errors.Is(err, errInvalidCredType) {
+		return "", errPasswordlessInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// код принимается только для тех учетных данных, на которые он выслан
+	_, err = consumePasswordlessScript.Run(ctx, redisClient, []string{passwordlessKey(userId)},
+		"code", authkit.HashCode(code), passwordlessMaxAttempts, credType, identifier).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", errPasswordlessInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	return userId, nil
+}
+
+// consumeMagicLink проверяет токен ссылки и возвращает аккаунт и учетные данные, на которые она выслана
+func consumeMagicLink(token string) (userId, credType, identifier string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := magicLinkUserId(token)
+	if !ok {
+		return "", "", "", errPasswordlessInvalid
+	}
+
+	result, err := consumePasswordlessScript.Run(ctx, redisClient, []string{passwordlessKey(userId)},
+		"link", authkit.HashCode(token), passwordlessMaxAttempts, "", "").StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return "", "", "", errPasswordlessInvalid
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if len(result) != 2 {
+		return "", "", "", errPasswordlessInvalid
+	}
+	return userId, result[0], result[1], nil
+}
+
+func requestLoginCode(c *gin.Context) {
+	var request struct {
+		Creds string `json:"creds" binding:"required"`
+		Value string `json:"value" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	if request.Creds != "email" && request.Creds != "phone" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Вход по коду возможен только по email или телефону"})
+		return
+	}
+
+	// как и при сбросе пароля, ответ не выдает, существует ли аккаунт
+	err := requestPasswordlessLogin(request.Creds, request.Value)
+	if err != nil && !errors.Is(err, errAccountNotFound) {
+		log.Printf("Ошибка отправки кода входа: %v", err)
+	}
+
+	recordAuthEvent(c, authEvent{
+		Type: auditPasswordlessRequested, UserID: auditUserId(request.Creds, request.Value),
+		CredType: request.Creds, Identifier: request.Value,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Если аккаунт существует, код отправлен"})
+}
+
+// loginWithCode обменивает код (creds, value, code) или токен ссылки (token) на пару токенов
+func loginWithCode(c *gin.Context) {
+	var request struct {
+		Creds string `json:"creds"`
+		Value string `json:"value"`
+		Code  string `json:"code"`
+		Token string `json:"token"`
+	}
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil || (request.Token == "" && (request.Creds == "" || request.Value == "" || request.Code == "")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нужно указать код с учетными данными или токен ссылки"})
+		return
+	}
+
+	// по ссылке учетные данные заранее неизвестны, поэтому блокировка считается только по IP
+	clientSubject := ipSubject(clientIP(c))
+	credSubject := ""
+	subjects := []string{clientSubject}
+	if request.Token == "" {
+		credSubject = loginSubject(request.Creds, request.Value)
+		subjects = append(subjects, credSubject)
+	}
+
+	retryAfter, err := loginLockedFor(subjects...)
+	if err != nil {
+		log.Printf("Не удалось проверить блокировку входа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if retryAfter > 0 {
+		respondLoginLocked(c, retryAfter)
+		return
+	}
+
+	var userId, credType, identifier string
+	if request.Token != "" {
+		userId, credType, identifier, err = consumeMagicLink(request.Token)
+	} else {
+		credType, identifier = request.Creds, request.Value
+		userId, err = consumePasswordlessCode(credType, identifier, request.Code)
+	}
+
+	if errors.Is(err, errPasswordlessInvalid) {
+		recordAuthEvent(c, authEvent{
+			Type: auditLoginFailure, UserID: auditUserId(credType, identifier),
+			CredType: credType, Identifier: identifier, Detail: "bad_passwordless_code",
+		})
+
+		lock, err := registerLoginFailure(clientSubject, appConfig.LoginIPMaxFailures)
+		if err != nil {
+			log.Printf("Не удалось учесть неудачную попытку входа: %v", err)
+		}
+		if credSubject != "" {
+			credLock, err := registerLoginFailure(credSubject, appConfig.LoginMaxFailures)
+			if err != nil {
+				log.Printf("Не удалось учесть неудачную попытку входа: %v", err)
+			}
+			lock = max(lock, credLock)
+		}
+		if lock > 0 {
+			respondLoginLocked(c, lock)
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Код невалиден или истек"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка входа по коду: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	err = resetLoginFailures(accountSubject(userId))
+	if err != nil {
+		log.Printf("Не удалось сбросить счетчик попыток входа: %v", err)
+	}
+
+	completeLogin(c, userId, credType, identifier)
+}
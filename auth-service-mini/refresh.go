@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	errRefreshTokenInvalid = errors.New("refresh токен невалиден или истек")
+	errRefreshTokenReused  = errors.New("повторное использование refresh токена, семейство отозвано")
+)
+
+// refreshFamily - цепочка refresh токенов, выданных в рамках одного входа
+type refreshFamily struct {
+	ID         string
+	UserID     string
+	CredType   string
+	Identifier string
+}
+
+// Сравнивает текущий токен семейства с предъявленным и атомарно ротирует его.
+// Если предъявлен уже использованный токен - семейство удаляется целиком, а в ленту
+// отзывов (KEYS[2]) уходит то же событие, что пишет addRevocation.
+// ARGV[4] - id семейства, ARGV[5] - нижняя граница ленты, ARGV[6] - срок события
+var rotateRefreshScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'current')
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	redis.call('XADD', KEYS[2], 'MINID', '~', ARGV[5], '*', 'type', 'sid', 'id', ARGV[4], 'ver', 0, 'exp', ARGV[6])
+	return -1
+end
+redis.call('HSET', KEYS[1], 'current', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+func refreshFamilyKey(familyId string) string {
+	return "auth:refresh:family:" + familyId
+}
+
+// createRefreshFamily заводит новое семейство и возвращает первый refresh токен.
+// Семейство одновременно является сессией: его id попадает в access токен как sid
+func createRefreshFamily(userId, credType, identifier string, device sessionDevice) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	familyId, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := newRefreshToken(familyId)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now().Unix()
+	key := refreshFamilyKey(familyId)
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, map[string]any{
+			"current":      hashRefreshToken(token),
+			"user_id":      userId,
+			"cred_type":    credType,
+			"identifier":   identifier,
+			"user_agent":   device.UserAgent,
+			"ip":           device.IP,
+			"created_at":   now,
+			"last_seen_at": now,
+		})
+		pipe.Expire(ctx, key, appConfig.RefreshTokenTTL)
+		pipe.SAdd(ctx, userRefreshFamiliesKey(userId), familyId)
+		pipe.Expire(ctx, userRefreshFamiliesKey(userId), appConfig.RefreshTokenTTL)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, familyId, nil
+}
+
+// rotateRefreshToken проверяет предъявленный токен и выдает вместо него новый
+func rotateRefreshToken(token string, device sessionDevice) (string, refreshFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	familyId, ok := refreshFamilyIdFromToken(token)
+	if !ok {
+		return "", refreshFamily{}, errRefreshTokenInvalid
+	}
+
+	key := refreshFamilyKey(familyId)
+	fields, err := redisClient.HMGet(ctx, key, "user_id", "cred_type", "identifier").Result()
+	if err != nil {
+		return "", refreshFamily{}, err
+	}
+	userId, _ := fields[0].(string)
+	credType, _ := fields[1].(string)
+	identifier, _ := fields[2].(string)
+
+	newToken, err := newRefreshToken(familyId)
+	if err != nil {
+		return "", refreshFamily{}, err
+	}
+
+	now := time.Now()
+	result, err := rotateRefreshScript.Run(ctx, redisClient, []string{key, revocationStreamKey},
+		hashRefreshToken(token),
+		hashRefreshToken(newToken),
+		appConfig.RefreshTokenTTL.Milliseconds(),
+		familyId,
+		now.Add(-appConfig.AccessTokenTTL).UnixMilli(),
+		now.Add(appConfig.AccessTokenTTL).Unix(),
+	).Int()
+	if err != nil {
+		return "", refreshFamily{}, err
+	}
+
+	switch result {
+	case 1:
+		redisClient.HSet(ctx, key, "ip", device.IP, "last_seen_at", time.Now().Unix())
+		return newToken, refreshFamily{familyId, userId, credType, identifier}, nil
+	case -1:
+		return "", refreshFamily{familyId, userId, credType, identifier}, errRefreshTokenReused
+	default:
+		return "", refreshFamily{}, errRefreshTokenInvalid
+	}
+}
+
+// deleteRefreshFamily отзывает семейство, к которому относится токен,
+// если оно принадлежит указанному пользователю
+func deleteRefreshFamily(userId, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	familyId, ok := refreshFamilyIdFromToken(token)
+	if !ok {
+		return errRefreshTokenInvalid
+	}
+
+	key := refreshFamilyKey(familyId)
+	owner, err := redisClient.HGet(ctx, key, "user_id").Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userId {
+		return errRefreshTokenInvalid
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.SRem(ctx, userRefreshFamiliesKey(userId), familyId)
+		publishSessionsRevoked(ctx, pipe, familyId)
+		return nil
+	})
+	return err
+}
+
+// Токен имеет вид <id семейства>.<случайная часть>
+func newRefreshToken(familyId string) (string, error) {
+	secret, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	return familyId + "." + secret, nil
+}
+
+func refreshFamilyIdFromToken(token string) (string, bool) {
+	familyId, secret, found := strings.Cut(token, ".")
+	if !found || familyId == "" || secret == "" {
+		return "", false
+	}
+	return familyId, true
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
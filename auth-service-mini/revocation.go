@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Остальные сервисы проверяют отзыв токенов локально по ленте auth:revocations:
+// каждый отзыв jti, завершение сессии и увеличение версии токенов пользователя
+// дописывается в поток Redis, а сервисы читают его через /revocations. Событие нужно
+// только до истечения последнего затронутого им access токена, поэтому поток
+// обрезается до AccessTokenTTL и новый сервис получает все актуальные отзывы с начала.
+
+const revocationStreamKey = "auth:revocations"
+
+const (
+	// revocationFeedWait - сколько /revocations ждет новых событий, прежде чем ответить пустым списком
+	revocationFeedWait  = 25 * time.Second
+	revocationFeedBatch = 1000
+)
+
+// Типы событий ленты отзывов
+const (
+	revocationJTI     = "jti"
+	revocationSession = "sid"
+	revocationVersion = "ver"
+)
+
+func revokedTokenKey(jti string) string {
+	return "auth:revoked:jti:" + jti
+}
+
+func tokenVersionKey(userId string) string {
+	return "auth:user:" + userId + ":token_version"
+}
+
+func userRefreshFamiliesKey(userId string) string {
+	return "auth:user:" + userId + ":refresh_families"
+}
+
+// revokeToken заносит jti в denylist до момента истечения токена
+func revokeToken(jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, revokedTokenKey(jti), 1, ttl)
+		addRevocation(ctx, pipe, revocationJTI, jti, 0, expiresAt)
+		return nil
+	})
+	return err
+}
+
+func isTokenRevoked(jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := redisClient.Exists(ctx, revokedTokenKey(jti)).Result()
+	return count > 0, err
+}
+
+func getTokenVersion(userId string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	version, err := redisClient.Get(ctx, tokenVersionKey(userId)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// revokeAllUserTokens делает недействительными все access токены пользователя
+// увеличением версии и удаляет все его семейства refresh токенов
+func revokeAllUserTokens(userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	familyIds, err := redisClient.SMembers(ctx, userRefreshFamiliesKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+
+	var version *redis.IntCmd
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		version = pipe.Incr(ctx, tokenVersionKey(userId))
+		for _, familyId := range familyIds {
+			pipe.Del(ctx, refreshFamilyKey(familyId))
+		}
+		pipe.Del(ctx, userRefreshFamiliesKey(userId))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return publishTokenVersion(ctx, userId, version.Val())
+}
+
+// addRevocation дописывает событие в ленту отзывов и обрезает из нее события,
+// которые пережили все access токены
+func addRevocation(ctx context.Context, pipe redis.Cmdable, kind, id string, version int64, expiresAt time.Time) *redis.StringCmd {
+	minId := strconv.FormatInt(time.Now().Add(-appConfig.AccessTokenTTL).UnixMilli(), 10)
+	return pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: revocationStreamKey,
+		MinID:  minId,
+		Approx: true,
+		Values: map[string]any{"type": kind, "id": id, "ver": version, "exp": expiresAt.Unix()},
+	})
+}
+
+// publishTokenVersion сообщает сервисам новую версию токенов пользователя: токены
+// с меньшей версией ими больше не принимаются
+func publishTokenVersion(ctx context.Context, userId string, version int64) error {
+	expiresAt := time.Now().Add(appConfig.AccessTokenTTL)
+	return addRevocation(ctx, redisClient, revocationVersion, userId, version, expiresAt).Err()
+}
+
+// publishSessionsRevoked сообщает сервисам о завершенных сессиях
+func publishSessionsRevoked(ctx context.Context, pipe redis.Cmdable, sessionIds ...string) {
+	expiresAt := time.Now().Add(appConfig.AccessTokenTTL)
+	for _, sessionId := range sessionIds {
+		addRevocation(ctx, pipe, revocationSession, sessionId, 0, expiresAt)
+	}
+}
+
+type revocationEvent struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Version   int64  `json:"ver,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// revocationFeedHandler отдает события ленты отзывов после курсора after. С wait=1
+// ответ задерживается до появления событий, но не дольше revocationFeedWait
+func revocationFeedHandler(c *gin.Context) {
+	after := c.DefaultQuery("after", "0")
+	args := &redis.XReadArgs{
+		Streams: []string{revocationStreamKey, after},
+		Count:   revocationFeedBatch,
+		Block:   -1,
+	}
+	if c.Query("wait") == "1" {
+		args.Block = revocationFeedWait
+	}
+
+	streams, err := redisClient.XRead(c.Request.Context(), args).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("Ошибка чтения ленты отзывов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	events := []revocationEvent{}
+	cursor := after
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			cursor = message.ID
+			event := revocationEvent{}
+			event.Type, _ = message.Values["type"].(string)
+			event.ID, _ = message.Values["id"].(string)
+			version, _ := message.Values["ver"].(string)
+			event.Version, _ = strconv.ParseInt(version, 10, 64)
+			expiresAt, _ := message.Values["exp"].(string)
+			event.ExpiresAt, _ = strconv.ParseInt(expiresAt, 10, 64)
+			events = append(events, event)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cursor": cursor, "events": events, "more": len(events) == revocationFeedBatch})
+}
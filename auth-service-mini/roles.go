@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"auth-kit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Роли хранятся в поле roles хеша аккаунта через запятую. Роль user есть у всех
+// и не хранится; пустое поле означает обычного пользователя.
+
+var errInvalidRole = errors.New("неизвестная роль")
+
+// adminBootstrapKey отмечает, что ADMIN_TOKEN уже использован для назначения первого администратора
+const adminBootstrapKey = "auth:admin:bootstrapped"
+
+var knownRoles = []string{authkit.RoleUser, authkit.RoleModerator, authkit.RoleAdmin}
+
+func getAccountRoles(userId string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value, err := redisClient.HGet(ctx, accountKey(userId), "roles").Result()
+	if errors.Is(err, redis.Nil) {
+		return []string{defaultRole}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{defaultRole}
+	for _, role := range strings.Split(value, ",") {
+		if role != "" && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// setAccountRoles заменяет роли аккаунта. Версия токенов увеличивается, чтобы
+// выданные access токены со старыми ролями перестали приниматься сервисами,
+// а при следующем refresh пришли уже новые роли
+func setAccountRoles(userId string, roles []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stored []string
+	for _, role := range roles {
+		if !slices.Contains(knownRoles, role) {
+			return errInvalidRole
+		}
+		if role != defaultRole && !slices.Contains(stored, role) {
+			stored = append(stored, role)
+		}
+	}
+
+	exists, err := redisClient.Exists(ctx, accountKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return errAccountNotFound
+	}
+
+	var version *redis.IntCmd
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, accountKey(userId), "roles", strings.Join(stored, ","))
+		version = pipe.Incr(ctx, tokenVersionKey(userId))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return publishTokenVersion(ctx, userId, version.Val())
+}
+
+// adminMiddleware ставится после authMiddleware и пропускает только токены с ролью admin.
+// Роли в токене актуальны: их смена увеличивает версию токенов
+func adminMiddleware(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+	if !slices.Contains(claims.Roles, authkit.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// bootstrapAdminHandler один раз назначает первого администратора по X-Admin-Token, равному ADMIN_TOKEN.
+// Дальше роли раздают администраторы через /admin/roles, а токен больше не принимается
+func bootstrapAdminHandler(c *gin.Context) {
+	token := c.GetHeader("X-Admin-Token")
+	if appConfig.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.AdminToken)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		return
+	}
+
+	var request struct {
+		UserId string `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fresh, err := redisClient.SetNX(ctx, adminBootstrapKey, request.UserId, 0).Result()
+	if err != nil {
+		log.Printf("Не удалось назначить первого администратора: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+	if !fresh {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Первый администратор уже назначен"})
+		return
+	}
+
+	err = setAccountRoles(request.UserId, []string{authkit.RoleAdmin})
+	if err != nil {
+		// токен остается годным, пока назначение не прошло
+		redisClient.Del(ctx, adminBootstrapKey)
+		if errors.Is(err, errAccountNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+			return
+		}
+		log.Printf("Ошибка сохранения ролей: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	roles, _ := getAccountRoles(request.UserId)
+	recordAuthEvent(c, authEvent{Type: auditRolesChanged, UserID: request.UserId, Detail: "bootstrap:" + strings.Join(roles, ",")})
+	c.JSON(http.StatusOK, gin.H{"user_id": request.UserId, "roles": roles})
+}
+
+// setRolesHandler назначает роли аккаунту; доступен только администраторам
+func setRolesHandler(c *gin.Context) {
+	var request struct {
+		UserId string   `json:"user_id" binding:"required"`
+		Roles  []string `json:"roles"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	err := setAccountRoles(request.UserId, request.Roles)
+	if errors.Is(err, errInvalidRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестная роль"})
+		return
+	}
+	if errors.Is(err, errAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения ролей: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	roles, _ := getAccountRoles(request.UserId)
+	recordAuthEvent(c, authEvent{Type: auditRolesChanged, UserID: request.UserId, Detail: strings.Join(roles, ",")})
+	c.JSON(http.StatusOK, gin.H{"user_id": request.UserId, "roles": roles})
+}
@@ -0,0 +1,15 @@
+package main
+
+import "auth-kit"
+
+var (
+	emailSender authkit.Sender
+	smsSender   authkit.Sender
+)
+
+// initSenders выбирает реализации по настройкам, см. authkit.SendersFromEnv
+func initSenders() error {
+	var err error
+	emailSender, smsSender, err = authkit.SendersFromEnv()
+	return err
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Сессия - это семейство refresh токенов (auth:refresh:family:<id>) с данными об устройстве.
+// Ее id передается в access токене как sid, поэтому удаление семейства сразу
+// делает недействительными и refresh, и access токены этой сессии.
+
+// last_seen_at обновляется не чаще раза в sessionTouchInterval, чтобы не писать в Redis на каждый запрос
+const sessionTouchInterval = time.Minute
+
+var errSessionNotFound = errors.New("сессия не найдена")
+
+type sessionDevice struct {
+	UserAgent string
+	IP        string
+}
+
+type session struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+func deviceFromRequest(c *gin.Context) sessionDevice {
+	return sessionDevice{UserAgent: clientUserAgent(c), IP: clientIP(c)}
+}
+
+// KEYS[1] - семейство, ARGV[1] - текущее время (с), ARGV[2] - интервал обновления (с)
+var touchSessionScript = redis.NewScript(`
+local lastSeen = redis.call('HGET', KEYS[1], 'last_seen_at')
+if not lastSeen then
+	return 0
+end
+if tonumber(ARGV[1]) - tonumber(lastSeen) >= tonumber(ARGV[2]) then
+	redis.call('HSET', KEYS[1], 'last_seen_at', ARGV[1])
+end
+return 1
+`)
+
+// touchSession сообщает, жива ли сессия, и отмечает время последней активности
+func touchSession(sessionId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	active, err := touchSessionScript.Run(ctx, redisClient, []string{refreshFamilyKey(sessionId)},
+		time.Now().Unix(), int64(sessionTouchInterval.Seconds())).Int()
+	return active == 1, err
+}
+
+func listSessions(userId, currentId string) ([]session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	familyIds, err := redisClient.SMembers(ctx, userRefreshFamiliesKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]session, 0, len(familyIds))
+	var expired []any
+	for _, familyId := range familyIds {
+		fields, err := redisClient.HMGet(ctx, refreshFamilyKey(familyId),
+			"user_id", "user_agent", "ip", "created_at", "last_seen_at").Result()
+		if err != nil {
+			return nil, err
+		}
+
+		owner, _ := fields[0].(string)
+		if owner != userId {
+			// семейство истекло или отозвано при повторном использовании токена
+			expired = append(expired, familyId)
+			continue
+		}
+
+		userAgent, _ := fields[1].(string)
+		ip, _ := fields[2].(string)
+		createdAt, _ := fields[3].(string)
+		lastSeenAt, _ := fields[4].(string)
+
+		item := session{
+			ID:        familyId,
+			UserAgent: userAgent,
+			IP:        ip,
+			Current:   familyId == currentId,
+		}
+		item.CreatedAt, _ = strconv.ParseInt(createdAt, 10, 64)
+		item.LastSeenAt, _ = strconv.ParseInt(lastSeenAt, 10, 64)
+		sessions = append(sessions, item)
+	}
+
+	if len(expired) > 0 {
+		redisClient.SRem(ctx, userRefreshFamiliesKey(userId), expired...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+	})
+	return sessions, nil
+}
+
+func revokeSession(userId, sessionId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	owner, err := redisClient.HGet(ctx, refreshFamilyKey(sessionId), "user_id").Result()
+	if errors.Is(err, redis.Nil) || (err == nil && owner != userId) {
+		return errSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, refreshFamilyKey(sessionId))
+		pipe.SRem(ctx, userRefreshFamiliesKey(userId), sessionId)
+		publishSessionsRevoked(ctx, pipe, sessionId)
+		return nil
+	})
+	return err
+}
+
+// revokeOtherSessions завершает все сессии пользователя, кроме keepId, и возвращает их число
+func revokeOtherSessions(userId, keepId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	familyIds, err := redisClient.SMembers(ctx, userRefreshFamiliesKey(userId)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var revoked []string
+	for _, familyId := range familyIds {
+		if familyId != keepId {
+			revoked = append(revoked, familyId)
+		}
+	}
+	if len(revoked) == 0 {
+		return 0, nil
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, familyId := range revoked {
+			pipe.Del(ctx, refreshFamilyKey(familyId))
+			pipe.SRem(ctx, userRefreshFamiliesKey(userId), familyId)
+		}
+		publishSessionsRevoked(ctx, pipe, revoked...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(revoked), nil
+}
+
+func listSessionsHandler(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+
+	sessions, err := listSessions(claims.Subject, claims.SessionID)
+	if err != nil {
+		log.Printf("Ошибка получения списка сессий: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func revokeSessionHandler(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+
+	err := revokeSession(claims.Subject, c.Param("id"))
+	if errors.Is(err, errSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сессия не найдена"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка завершения сессии: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{Type: auditSessionRevoked, UserID: claims.Subject, SessionID: c.Param("id")})
+	c.JSON(http.StatusOK, gin.H{"message": "Сессия завершена"})
+}
+
+func revokeOtherSessionsHandler(c *gin.Context) {
+	claims := c.MustGet(claimsContextKey).(*AccessClaims)
+
+	if claims.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Текущая сессия не определена, войдите заново"})
+		return
+	}
+
+	count, err := revokeOtherSessions(claims.Subject, claims.SessionID)
+	if err != nil {
+		log.Printf("Ошибка завершения сессий: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{
+		Type: auditSessionRevoked, UserID: claims.Subject, SessionID: claims.SessionID,
+		Detail: "others:" + strconv.Itoa(count),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Остальные сессии завершены", "revoked": count})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultRole = "user"
+
+const claimsContextKey = "claims"
+
+// AccessClaims - содержимое access токена
+type AccessClaims struct {
+	AnketaID  string   `json:"anketa_id,omitempty"`
+	Roles     []string `json:"roles"`
+	Version   int64    `json:"ver"`
+	SessionID string   `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type tokenIdentity struct {
+	UserID    string
+	AnketaID  string
+	Roles     []string
+	SessionID string
+}
+
+func issueAccessToken(identity tokenIdentity) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	roles := identity.Roles
+	if len(roles) == 0 {
+		roles = []string{defaultRole}
+	}
+
+	version, err := getTokenVersion(identity.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := AccessClaims{
+		AnketaID:  identity.AnketaID,
+		Roles:     roles,
+		Version:   version,
+		SessionID: identity.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   identity.UserID,
+			Issuer:    appConfig.Issuer,
+			Audience:  jwt.ClaimStrings{appConfig.Audience},
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(appConfig.AccessTokenTTL)),
+		},
+	}
+
+	key, err := signingKeys.active()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+func parseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return signingKeys.publicKey(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(appConfig.Issuer),
+		jwt.WithAudience(appConfig.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("токен невалиден")
+	}
+
+	return claims, nil
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ключ из приложения B RFC 6238 ("12345678901234567890") в base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// в RFC коды из 8 цифр, здесь их последние 6
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("t=%d: %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("t=%d: код %s, ожидался %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	codeAt := func(offset int64) string {
+		code, err := totpCode(rfc6238Secret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"текущий шаг", rfc6238Secret, codeAt(0), step, true},
+		{"шаг назад", rfc6238Secret, codeAt(-1), step - 1, true},
+		{"шаг вперед", rfc6238Secret, codeAt(1), step + 1, true},
+		{"два шага назад", rfc6238Secret, codeAt(-2), 0, false},
+		{"два шага вперед", rfc6238Secret, codeAt(2), 0, false},
+		{"секрет в нижнем регистре", strings.ToLower(rfc6238Secret), codeAt(0), step, true},
+		{"короткий код", rfc6238Secret, codeAt(0)[:5], 0, false},
+		{"длинный код", rfc6238Secret, codeAt(0) + "0", 0, false},
+		{"некорректный секрет", "not-base32!", codeAt(0), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := validateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("validateTOTP = (%d, %v), ожидалось (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
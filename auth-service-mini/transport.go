@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"log"
+	"strconv"
+	"strings"
 	"github.com/gin-gonic/gin"
 )
 
@@ -21,24 +24,27 @@ func saveUserRegToRedis(c *gin.Context) {
 		return
 	}
 
-	// Сохраняем пароли в Redis
-	err := saveShitToRedis(userStrings.Login, userStrings.Email, userStrings.Phone, userStrings.Password)
-	if err != nil {
-		log.Printf("Ошибка сохранения паролей в Redis: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения данных"})
+	if userStrings.UserId == "" || userStrings.Login == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id и login обязательны"})
 		return
 	}
 
-	// Сохраняем user_id в Redis по всем кредам
-	if userStrings.UserId != "" {
-		err := saveUserIdToAllCredTypes(userStrings.Login, userStrings.Email, userStrings.Phone, userStrings.UserId)
-		if err != nil {
-			log.Printf("Ошибка сохранения user_id в Redis: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения user_id"})
-			return
-		}
+	// Сохраняем аккаунт в Redis
+	err := saveShitToRedis(userStrings.UserId, userStrings.Login, userStrings.Email, userStrings.Phone, userStrings.Password)
+	if errors.Is(err, errCredentialTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Учетные данные уже заняты"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения аккаунта в Redis: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения данных"})
+		return
 	}
 
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialLinked, UserID: userStrings.UserId, CredType: "login",
+		Identifier: userStrings.Login, Detail: "account_created",
+	})
 	c.JSON(http.StatusOK, gin.H{"message": "Данные успешно сохранены"})
 }
 
@@ -60,6 +66,10 @@ func saveAnketaId(c *gin.Context) {
 		return
 	}
 
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialLinked, UserID: auditUserId(request.CredType, request.Identifier),
+		CredType: request.CredType, Identifier: request.Identifier, Detail: "anketa_id:" + request.AnketaId,
+	})
 	c.JSON(http.StatusOK, gin.H{"message": "ID анкеты успешно сохранен"})
 }
 
@@ -80,7 +90,7 @@ func saveAnketaIdToAll(c *gin.Context) {
 	}
 
 	log.Printf("Получен запрос на сохранение anketa_id: login=%s, email=%s, phone=%s, anketa_id=%s", 
-		request.Login, request.Email, request.Phone, request.AnketaId)
+		maskIdentifier("login", request.Login), maskIdentifier("email", request.Email), maskIdentifier("phone", request.Phone), request.AnketaId)
 
 	if request.Login == "" {
 		log.Printf("ОШИБКА: login пустой!")
@@ -96,6 +106,10 @@ func saveAnketaIdToAll(c *gin.Context) {
 	}
 
 	log.Printf("anketa_id успешно сохранен для всех типов учетных данных")
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialLinked, UserID: auditUserId("login", request.Login),
+		CredType: "login", Identifier: request.Login, Detail: "anketa_id:" + request.AnketaId,
+	})
 	log.Printf("=== СОХРАНЕНИЕ ЗАВЕРШЕНО ===")
 	c.JSON(http.StatusOK, gin.H{"message": "ID анкеты успешно сохранен по всем типам учетных данных"})
 }
@@ -114,11 +128,11 @@ func getAnketaId(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Запрос anketa_id для %s: %s", request.CredType, request.Identifier)
+	log.Printf("Запрос anketa_id для %s: %s", request.CredType, maskIdentifier(request.CredType, request.Identifier))
 
 	anketaId, err := getAnketaIdFromRedis(request.CredType, request.Identifier)
 	if err != nil {
-		log.Printf("anketa_id не найден для %s: %s, ошибка: %v", request.CredType, request.Identifier, err)
+		log.Printf("anketa_id не найден для %s: %s, ошибка: %v", request.CredType, maskIdentifier(request.CredType, request.Identifier), err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "ID анкеты не найден"})
 		return
 	}
@@ -142,7 +156,7 @@ func getAllUserCredsHandler(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Запрос всех учетных данных для %s: %s", request.CredType, request.Identifier)
+	log.Printf("Запрос всех учетных данных для %s: %s", request.CredType, maskIdentifier(request.CredType, request.Identifier))
 
 	login, email, phone, err := getAllUserCreds(request.CredType, request.Identifier)
 	if err != nil {
@@ -151,7 +165,8 @@ func getAllUserCredsHandler(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Получены все учетные данные: login=%s, email=%s, phone=%s", login, email, phone)
+	log.Printf("Получены все учетные данные: login=%s, email=%s, phone=%s",
+		maskIdentifier("login", login), maskIdentifier("email", email), maskIdentifier("phone", phone))
 	log.Printf("=== УЧЕТНЫЕ ДАННЫЕ УСПЕШНО ПОЛУЧЕНЫ ===")
 
 	c.JSON(http.StatusOK, gin.H{
@@ -179,6 +194,10 @@ func saveUserId(c *gin.Context) {
 		return
 	}
 
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialLinked, UserID: request.UserId,
+		CredType: request.CredType, Identifier: request.Identifier,
+	})
 	c.JSON(http.StatusOK, gin.H{"message": "ID пользователя успешно сохранен"})
 }
 
@@ -201,6 +220,10 @@ func saveUserIdToAll(c *gin.Context) {
 		return
 	}
 
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialLinked, UserID: request.UserId,
+		CredType: "login", Identifier: request.Login, Detail: "all",
+	})
 	c.JSON(http.StatusOK, gin.H{"message": "ID пользователя успешно сохранен по всем типам учетных данных"})
 }
 
@@ -223,3 +246,116 @@ func getUserId(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"user_id": userId})
 }
+
+func setCredentialVerifiedHandler(c *gin.Context) {
+	var request struct {
+		UserId   string `json:"user_id" binding:"required"`
+		CredType string `json:"cred_type" binding:"required"`
+		Verified bool   `json:"verified"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	err := setCredentialVerified(request.UserId, request.CredType, request.Verified)
+	if errors.Is(err, errInvalidCredType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Подтверждать можно только email или телефон"})
+		return
+	}
+	if errors.Is(err, errAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения статуса подтверждения: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialVerified, UserID: request.UserId,
+		CredType: request.CredType, Detail: strconv.FormatBool(request.Verified),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Статус подтверждения сохранен"})
+}
+
+// updateCredentialsHandler меняет учетные данные аккаунта после их изменения в user-service
+func updateCredentialsHandler(c *gin.Context) {
+	var request struct {
+		UserId        string `json:"user_id" binding:"required"`
+		Login         string `json:"login"`
+		Email         string `json:"email"`
+		Phone         string `json:"phone"`
+		PasswordHash  string `json:"password_hash"`
+		EmailVerified *bool  `json:"email_verified"`
+		PhoneVerified *bool  `json:"phone_verified"`
+		// откат user-service: применить, только если аккаунт все еще хранит эти значения
+		Expected *struct {
+			Login        string `json:"login"`
+			Email        string `json:"email"`
+			Phone        string `json:"phone"`
+			PasswordHash string `json:"password_hash"`
+		} `json:"expected"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные запроса"})
+		return
+	}
+
+	update := credentialUpdate{
+		Login:         request.Login,
+		Email:         request.Email,
+		Phone:         request.Phone,
+		PasswordHash:  request.PasswordHash,
+		EmailVerified: request.EmailVerified,
+		PhoneVerified: request.PhoneVerified,
+	}
+	if request.Expected != nil {
+		update.Expected = &credentialExpectation{
+			Login:        request.Expected.Login,
+			Email:        request.Expected.Email,
+			Phone:        request.Expected.Phone,
+			PasswordHash: request.Expected.PasswordHash,
+		}
+	}
+
+	previousHash, err := updateAccountCredentials(request.UserId, update)
+	if errors.Is(err, errAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Аккаунт не найден"})
+		return
+	}
+	if errors.Is(err, errCredentialTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Учетные данные уже заняты"})
+		return
+	}
+	if errors.Is(err, errCredentialsMismatch) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Учетные данные аккаунта уже изменены"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка обновления учетных данных: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка на стороне сервера"})
+		return
+	}
+
+	var changed []string
+	if request.Login != "" {
+		changed = append(changed, "login")
+	}
+	if request.Email != "" {
+		changed = append(changed, "email")
+	}
+	if request.Phone != "" {
+		changed = append(changed, "phone")
+	}
+	if request.PasswordHash != "" {
+		changed = append(changed, "password")
+	}
+	recordAuthEvent(c, authEvent{
+		Type: auditCredentialsChanged, UserID: request.UserId, Detail: strings.Join(changed, ","),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Учетные данные обновлены", "previous_password_hash": previousHash})
+}
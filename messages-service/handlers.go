@@ -1,6 +1,7 @@
 package main
 
 import (
+	"auth-kit"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -62,6 +63,13 @@ func sendMessage(c *gin.Context) {
 		return
 	}
 
+	// Отправлять можно только от имени своей анкеты
+	claims, _ := authkit.ClaimsFromContext(c)
+	if claims == nil || claims.AnketaID == "" || claims.AnketaID != req.SenderID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
 	message := Message{
 		ID:         primitive.NewObjectID(),
 		SenderID:   req.SenderID,
@@ -96,7 +104,7 @@ func getConversation(c *gin.Context) {
 		},
 	}
 
-	opts := options.Find().SetSort(bson.D{{"timestamp", 1}})
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
 	cursor, err := messagesCollection.Find(context.Background(), filter, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get conversation"})
@@ -221,17 +229,99 @@ func markAsRead(c *gin.Context) {
 		return
 	}
 
-	filter := bson.M{"_id": objectId}
+	// Прочитанным сообщение может отметить только получатель
+	claims, _ := authkit.ClaimsFromContext(c)
+	if claims == nil || claims.AnketaID == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	filter := bson.M{"_id": objectId, "receiverId": claims.AnketaID}
 	update := bson.M{"$set": bson.M{"read": true}}
 
-	_, err = messagesCollection.UpdateOne(context.Background(), filter, update)
+	result, err := messagesCollection.UpdateOne(context.Background(), filter, update)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
 		return
 	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Message marked as read",
 	})
 }
+
+// Удаление всей переписки анкеты при удалении аккаунта
+func purgeAnketaMessages(c *gin.Context) {
+	var req struct {
+		AnketaID string `json:"anketa_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"senderId": req.AnketaID},
+			{"receiverId": req.AnketaID},
+		},
+	}
+
+	result, err := messagesCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"deleted": result.DeletedCount,
+	})
+}
+
+// Выгрузка всей переписки анкеты для запроса персональных данных
+func exportAnketaMessages(c *gin.Context) {
+	var req struct {
+		AnketaID string `json:"anketa_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"senderId": req.AnketaID},
+			{"receiverId": req.AnketaID},
+		},
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	cursor, err := messagesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export messages"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	messages := []Message{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ConversationResponse{
+		Messages: messages,
+	})
+}
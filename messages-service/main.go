@@ -1,8 +1,13 @@
 package main
 
 import (
+	"auth-kit"
+	"context"
 	"log"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
@@ -26,9 +31,13 @@ func main() {
 	}
 
 	messagesCollection = client.Database("krya").Collection("messages")
+	ensureIndexes()
 
 	// Настройка роутера
 	router := gin.Default()
+	if err := router.SetTrustedProxies(authkit.TrustedProxiesFromEnv()); err != nil {
+		log.Fatal(err)
+	}
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
@@ -41,12 +50,37 @@ func main() {
 		c.Next()
 	})
 
+	auth := authkit.NewAuthenticator(authkit.ConfigFromEnv())
+
 	// API endpoints
-	router.POST("/send", sendMessage)
+	router.POST("/send", auth.Middleware(), sendMessage)
 	router.GET("/conversation/:senderId/:receiverId", getConversation)
 	router.GET("/conversations/:userId", getUserConversations)
-	router.PUT("/read/:messageId", markAsRead)
+	router.PUT("/read/:messageId", auth.Middleware(), markAsRead)
+
+	// служебные запросы user-service при окончательном удалении аккаунта
+	internalKeys, err := authkit.InternalKeysFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	router.POST("/internal/purge", authkit.RequireInternal(internalKeys), purgeAnketaMessages)
+	router.POST("/internal/export", authkit.RequireInternal(internalKeys), exportAnketaMessages)
 
 	log.Println("Messages service starting on port 8005...")
 	router.Run(":8005")
 }
+
+// ensureIndexes создает индексы под запросы диалогов: переписка двух анкет
+// (в обе стороны) с сортировкой по времени и список диалогов с непрочитанными
+func ensureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := messagesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "senderId", Value: 1}, {Key: "receiverId", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "receiverId", Value: 1}, {Key: "read", Value: 1}}},
+	})
+	if err != nil {
+		log.Println("Не удалось создать индексы сообщений:", err)
+	}
+}
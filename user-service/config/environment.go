@@ -1,7 +1,35 @@
 package config
 
-import "github.com/joho/godotenv"
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
 
 func LoadEnv() error {
 	return godotenv.Load()
 }
+
+func GetEnv(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// GetDuration читает длительность вида "720h"; при ошибке разбора используется fallback
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Println("Некорректное значение", key, err)
+		return fallback
+	}
+	return duration
+}
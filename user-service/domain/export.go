@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// ExportJob - выгрузка персональных данных пользователя. Архив собирает ExportWorker
+// из данных всех сервисов; готовый архив хранится до ExpiresAt
+type ExportJob struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	FileID        string
+	Size          int64
+	LastError     string
+	CreatedAt     time.Time
+	FinishedAt    time.Time
+	ExpiresAt     time.Time
+}
+
+func NewExportJob(userID uuid.UUID) ExportJob {
+	now := time.Now()
+	return ExportJob{
+		ID:            uuid.New(),
+		UserID:        userID,
+		Status:        ExportPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// IsActive - архив еще собирается или уже готов и не истек
+func (j *ExportJob) IsActive(now time.Time) bool {
+	switch j.Status {
+	case ExportPending, ExportRunning:
+		return true
+	case ExportReady:
+		return now.Before(j.ExpiresAt)
+	}
+	return false
+}
+
+type ExportRepo interface {
+	Create(job ExportJob) error
+	FindByID(id uuid.UUID) (ExportJob, error)
+	FindLatest(userID uuid.UUID) (ExportJob, bool, error)
+	Claim(now time.Time, lease time.Duration) (ExportJob, bool, error)
+	MarkReady(id uuid.UUID, fileID string, size int64, expiresAt time.Time) error
+	Reschedule(id uuid.UUID, next time.Time, lastError string) error
+	MarkFailed(id uuid.UUID, lastError string) error
+	FindExpired(now time.Time) ([]ExportJob, error)
+	FindByUser(userID uuid.UUID) ([]ExportJob, error)
+	Delete(id uuid.UUID) error
+}
+
+// ExportStorage хранит готовые архивы
+type ExportStorage interface {
+	Save(name string, data io.Reader) (string, error)
+	Open(fileID string) (io.ReadCloser, error)
+	Delete(fileID string) error
+}
+
+type ExportService interface {
+	RequestExport(userID uuid.UUID) (ExportJob, error)
+	GetExport(userID, jobID uuid.UUID) (ExportJob, error)
+	OpenExport(userID, jobID uuid.UUID) (io.ReadCloser, ExportJob, error)
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RedactedValue пишется в историю вместо хеша пароля
+const RedactedValue = "[скрыто]"
+
+// ChangeRecord - запись истории изменений пользователя: одно поле в одном обновлении
+type ChangeRecord struct {
+	ID        string
+	UserID    uuid.UUID
+	Field     string
+	OldValue  string
+	NewValue  string
+	Actor     string
+	Version   int64
+	ChangedAt time.Time
+}
+
+// NewChangeRecords описывает обновление old полями update от имени actor.
+// Неизменившиеся поля пропускаются, пароль всегда скрыт
+func NewChangeRecords(old User, update UserUpdate, actor string, version int64) []ChangeRecord {
+	now := time.Now()
+	var records []ChangeRecord
+	for _, field := range []string{FieldLogin, FieldEmail, FieldPhone, FieldPassword} {
+		newValue, ok := update.FieldsToUpdate[field]
+		if !ok {
+			continue
+		}
+		oldValue := old.FieldValue(field)
+		if field == FieldPassword {
+			oldValue, newValue = RedactedValue, RedactedValue
+		} else if oldValue == newValue {
+			continue
+		}
+		records = append(records, ChangeRecord{
+			UserID:    old.ID,
+			Field:     field,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			Actor:     actor,
+			Version:   version,
+			ChangedAt: now,
+		})
+	}
+	return records
+}
+
+// HistoryRepo читает историю изменений; записи добавляет UserRepo.Update вместе с самим изменением
+type HistoryRepo interface {
+	// FindByUser отдает записи от новых к старым, начиная с записи перед before (пустой - с последней)
+	FindByUser(userID uuid.UUID, before string, limit int) ([]ChangeRecord, error)
+}
@@ -0,0 +1,14 @@
+package domain
+
+// ClientInfo - данные клиента, от имени которого user-service входит в auth-service
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// AuthResponse - ответ auth-service на вход, который отдается клиенту без изменений
+type AuthResponse struct {
+	StatusCode int
+	Body       []byte
+	RetryAfter string
+}
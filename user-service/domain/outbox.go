@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+// OutboxUserRegistered - сообщение о регистрации, по которому auth-service создает аккаунт
+const OutboxUserRegistered = "user_registered"
+
+// OutboxMessage - сообщение для другого сервиса, записанное в одной транзакции с изменением,
+// которое его породило. Доставляет его диспетчер, повторяя попытки до MaxAttempts
+type OutboxMessage struct {
+	ID             uuid.UUID
+	Type           string
+	UserID         uuid.UUID
+	Payload        map[string]any
+	IdempotencyKey string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastError      string
+	CreatedAt      time.Time
+}
+
+func NewOutboxMessage(messageType string, userID uuid.UUID, payload map[string]any) OutboxMessage {
+	id := uuid.New()
+	now := time.Now()
+	return OutboxMessage{
+		ID:             id,
+		Type:           messageType,
+		UserID:         userID,
+		Payload:        payload,
+		IdempotencyKey: messageType + ":" + id.String(),
+		Status:         OutboxPending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+}
+
+type OutboxRepo interface {
+	// Claim берет сообщение, время доставки которого наступило, и откладывает его на lease,
+	// чтобы при падении диспетчера сообщение вернулось в очередь
+	Claim(now time.Time, lease time.Duration) (OutboxMessage, bool, error)
+	MarkDelivered(id uuid.UUID) error
+	Reschedule(id uuid.UUID, next time.Time, lastError string) error
+	DeadLetter(id uuid.UUID, lastError string) error
+	// DeleteByUser стирает сообщения пользователя при окончательном удалении аккаунта
+	DeleteByUser(userID uuid.UUID) error
+}
@@ -24,6 +24,6 @@ func WithPhone(phone valueObjects.Phone) UpdateOption {
 
 func WithPassword(password valueObjects.Password) UpdateOption {
 	return func(update *UserUpdate) {
-		update.FieldsToUpdate[FieldEmail] = password.String()
+		update.FieldsToUpdate[FieldPassword] = password.String()
 	}
 }
@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+// Поля, по которым можно сортировать список пользователей
+const (
+	SortByCreatedAt = "created_at"
+	SortByLogin     = "login"
+	SortByEmail     = "email"
+)
+
+// UserListQuery - выборка пользователей для администратора. Пустые поля не фильтруют
+type UserListQuery struct {
+	// префикс логина, email или телефона
+	Search        string
+	EmailVerified *bool
+	PhoneVerified *bool
+	CreatedFrom   time.Time
+	CreatedTo     time.Time
+	SortBy        string
+	Desc          bool
+	// курсор из UserPage.NextCursor предыдущей страницы с той же сортировкой
+	Cursor string
+	Limit  int
+}
+
+type UserPage struct {
+	Users []User
+	// пустой, если страница последняя
+	NextCursor string
+}
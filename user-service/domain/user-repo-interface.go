@@ -1,14 +1,30 @@
 package domain
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type UserRepo interface {
 	Create(u User) error
-	Update(id uuid.UUID, update UserUpdate) error
+	CreateWithOutbox(u User, message OutboxMessage) error
+	// SetStatus меняет статус, только если пользователь все еще в статусе from
+	SetStatus(id uuid.UUID, from, to string) error
+	// FailRegistration переводит незавершенную регистрацию в failed и ставит ее на удаление в purgeAt
+	FailRegistration(id uuid.UUID, purgeAt time.Time) error
+	// Update вместе с изменением записывает changes в историю
+	Update(id uuid.UUID, update UserUpdate, expectedVersion int64, changes []ChangeRecord) error
 	Delete(id uuid.UUID) error
+	ScheduleDeletion(id uuid.UUID, purgeAt time.Time) error
+	Restore(id uuid.UUID) error
+	FindDueForPurge(now time.Time, limit int) ([]User, error)
+	List(query UserListQuery) (UserPage, error)
 	FindByID(id uuid.UUID) (User, error)
 	FindByLogin(login string) (User, error)
+	FindByCredential(credType, value string) (User, error)
 	ExistsByEmail(email string) (bool, error)
 	ExistsByLogin(login string) (bool, error)
 	ExistsByPhone(phone string) (bool, error)
+	SetVerified(id uuid.UUID, channel VerificationChannel, destination string, verified bool) error
 }
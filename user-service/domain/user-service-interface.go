@@ -1,14 +1,25 @@
 package domain
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type UserService interface {
 	Register(login, email, phone, password string) (uuid.UUID, error)
-	Login(login, password string) (string, error)
-	Delete(id uuid.UUID) error
-	Update(id uuid.UUID, opts ...UpdateOption) error
+	Login(credType, identifier, password string, client ClientInfo) (AuthResponse, error)
+	Delete(id uuid.UUID) (time.Time, error)
+	Deactivate(id uuid.UUID) error
+	Restore(credType, identifier, password string, client ClientInfo) (AuthResponse, error)
+	RestoreByID(id uuid.UUID) error
+	Update(id uuid.UUID, expectedVersion int64, actor string, opts ...UpdateOption) error
+	GetHistory(id uuid.UUID, before string, limit int) ([]ChangeRecord, error)
 	GetUserByID(id uuid.UUID) (User, error)
+	ListUsers(query UserListQuery) (UserPage, error)
 	CheckLoginExists(login string) (bool, error)
 	CheckEmailExists(email string) (bool, error)
 	CheckPhoneExists(phone string) (bool, error)
+	SendVerificationCode(id uuid.UUID, channel VerificationChannel) error
+	ConfirmVerification(id uuid.UUID, channel VerificationChannel, code string) error
 }
@@ -3,8 +3,8 @@ package domain
 const (
 	FieldLogin    = "login"
 	FieldEmail    = "email"
-	FieldPhone    = "phoneNumber"
-	FieldPassword = "password"
+	FieldPhone    = "phone_number"
+	FieldPassword = "password_hash"
 )
 
 type UserUpdate struct {
@@ -1,32 +1,79 @@
 package domain
 
 import (
+	"time"
 	"user-service/valueObjects"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Пользователь активен, только когда регистрация подтверждена и в auth-service.
+// Деактивированный и ожидающий удаления пользователь скрыт, но его можно восстановить
+const (
+	UserStatusPending          = "pending"
+	UserStatusActive           = "active"
+	UserStatusFailed           = "failed"
+	UserStatusDeactivated      = "deactivated"
+	UserStatusDeletionSchedule = "deletion_scheduled"
+)
+
+// User - пользователь. PasswordHash заполнен только у нового пользователя:
+// хеш уходит в auth-service и в базе не хранится
 type User struct {
-	ID           uuid.UUID `bson:"id"`
-	Login        valueObjects.Login `bson:"login"`
-	PasswordHash valueObjects.Password `bson:"password_hash"`
-	PhoneNumber  valueObjects.Phone `bson:"phone_number"`
-	Email        valueObjects.Email `bson:"email"`
+	ID            uuid.UUID             `bson:"id"`
+	Login         valueObjects.Login    `bson:"login"`
+	PasswordHash  valueObjects.Password `bson:"password_hash"`
+	PhoneNumber   valueObjects.Phone    `bson:"phone_number"`
+	Email         valueObjects.Email    `bson:"email"`
+	EmailVerified bool                  `bson:"email_verified"`
+	PhoneVerified bool                  `bson:"phone_verified"`
+	Status        string                `bson:"status"`
+	// момент окончательного удаления, если оно запланировано
+	PurgeAt time.Time `bson:"purge_at,omitempty"`
+	// растет при каждом изменении; отдается клиенту как ETag
+	Version   int64     `bson:"version"`
+	CreatedAt time.Time `bson:"created_at"`
 }
 
 func NewUser(login valueObjects.Login, password valueObjects.Password, phone valueObjects.Phone, email valueObjects.Email) User {
 	id := uuid.New()
 	return User{
-		id,
-		login,
-		password,
-		phone,
-		email,
+		ID:           id,
+		Login:        login,
+		PasswordHash: password,
+		PhoneNumber:  phone,
+		Email:        email,
+		Status:       UserStatusPending,
+		CreatedAt:    time.Now(),
+	}
+}
+
+func (u *User) IsActive() bool {
+	return u.Status == UserStatusActive
+}
+
+// IsRestorable - пользователь сам скрыл аккаунт или запросил удаление, и срок восстановления не истек
+func (u *User) IsRestorable() bool {
+	switch u.Status {
+	case UserStatusDeactivated:
+		return true
+	case UserStatusDeletionSchedule:
+		return time.Now().Before(u.PurgeAt)
 	}
+	return false
 }
 
-func (u *User) CheckPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash.String()), []byte(password))
-	return err == nil
+// FieldValue возвращает текущее значение поля из UserUpdate
+func (u *User) FieldValue(field string) string {
+	switch field {
+	case FieldLogin:
+		return u.Login.String()
+	case FieldEmail:
+		return u.Email.String()
+	case FieldPhone:
+		return u.PhoneNumber.String()
+	case FieldPassword:
+		return u.PasswordHash.String()
+	}
+	return ""
 }
@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VerificationChannel string
+
+const (
+	ChannelEmail VerificationChannel = "email"
+	ChannelPhone VerificationChannel = "phone"
+)
+
+func (c VerificationChannel) Valid() bool {
+	return c == ChannelEmail || c == ChannelPhone
+}
+
+// VerificationCode - одноразовый код подтверждения, хранится только хеш.
+// Destination - адрес или телефон, на который ушел код
+type VerificationCode struct {
+	UserID      uuid.UUID
+	Channel     VerificationChannel
+	Destination string
+	CodeHash    string
+	Attempts    int
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+type VerificationRepo interface {
+	Save(code VerificationCode) error
+	Find(userID uuid.UUID, channel VerificationChannel) (VerificationCode, error)
+	IncrementAttempts(userID uuid.UUID, channel VerificationChannel) (int, error)
+	Delete(userID uuid.UUID, channel VerificationChannel) error
+	DeleteByUser(userID uuid.UUID) error
+}
+
+// Sender доставляет код пользователю по email или SMS
+type Sender interface {
+	Send(ctx context.Context, to, message string) error
+}
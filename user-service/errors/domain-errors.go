@@ -20,12 +20,59 @@ var ErrLoginAlreadyExists LoginAlreadyExists = errors.New("Такой логин
 var ErrEmailAlreadyExists EmailAlreadyExists = errors.New("Такой адрес электронной почты уже зарегистрирован!")
 var ErrPhoneAlreadyExists PhoneAlreadyExists = errors.New("Такой номер телефона уже зарегистрирован!")
 
+type CredentialsUpdateError error
+
+var ErrCredentialsTaken CredentialsUpdateError = errors.New("Логин, email или телефон уже заняты!")
+var ErrCredentialsSyncFailed CredentialsUpdateError = errors.New("Не удалось обновить данные для входа, попробуйте позже!")
+var ErrCredentialsMismatch CredentialsUpdateError = errors.New("Данные для входа в auth-service уже изменены")
+
+type VersionConflict error
+
+var ErrVersionConflict VersionConflict = errors.New("Данные уже изменены с другого устройства, обновите их и повторите!")
+
 type LoginNotExists error
 type IncorrectPassword error
 
 var ErrLoginNotExists LoginNotExists = errors.New("Неверный логин!")
 var ErrPasswordNotExists LoginNotExists = errors.New("Неверный Пароль!")
 
+type UserNotActive error
+
+var ErrUserNotActive UserNotActive = errors.New("Регистрация еще не завершена, попробуйте войти чуть позже!")
+
 type TokenGenerationFailed error
 
 var ErrTokenGenerationFailed TokenGenerationFailed = errors.New("Произошла ошибка при генерации JWT токена")
+
+type LoginError error
+
+var ErrInvalidCredType LoginError = errors.New("Войти можно по логину, email или телефону!")
+var ErrAuthUnavailable LoginError = errors.New("Сервис авторизации недоступен, попробуйте позже!")
+
+type AccountLifecycleError error
+
+var ErrUserNotFound AccountLifecycleError = errors.New("Пользователь не найден!")
+var ErrAlreadyDeactivated AccountLifecycleError = errors.New("Аккаунт уже деактивирован!")
+var ErrNotRestorable AccountLifecycleError = errors.New("Аккаунт не деактивирован или срок восстановления истек!")
+var ErrAccountSyncFailed AccountLifecycleError = errors.New("Не удалось изменить статус аккаунта, попробуйте позже!")
+
+type ExportError error
+
+var ErrExportNotFound ExportError = errors.New("Выгрузка не найдена!")
+var ErrExportNotReady ExportError = errors.New("Выгрузка еще готовится, попробуйте позже!")
+
+type VerificationError error
+
+var ErrInvalidVerificationChannel VerificationError = errors.New("Подтвердить можно только email или телефон!")
+var ErrAlreadyVerified VerificationError = errors.New("Уже подтверждено!")
+var ErrVerificationCodeInvalid VerificationError = errors.New("Неверный или просроченный код подтверждения!")
+var ErrVerificationTooFrequent VerificationError = errors.New("Код уже отправлен, попробуйте позже!")
+
+type HistoryError error
+
+var ErrInvalidHistoryCursor HistoryError = errors.New("Неверный курсор истории изменений!")
+
+type UserListError error
+
+var ErrInvalidListSort UserListError = errors.New("Сортировать можно по created_at, login или email!")
+var ErrInvalidListCursor UserListError = errors.New("Неверный курсор списка пользователей!")
@@ -0,0 +1,301 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type exportDTO struct {
+	ID            string    `bson:"id"`
+	UserID        string    `bson:"user_id"`
+	Status        string    `bson:"status"`
+	Attempts      int       `bson:"attempts"`
+	NextAttemptAt time.Time `bson:"next_attempt_at"`
+	FileID        string    `bson:"file_id"`
+	Size          int64     `bson:"size"`
+	LastError     string    `bson:"last_error"`
+	CreatedAt     time.Time `bson:"created_at"`
+	FinishedAt    time.Time `bson:"finished_at"`
+	ExpiresAt     time.Time `bson:"expires_at"`
+}
+
+func convertDTOToExport(dto exportDTO) (domain.ExportJob, error) {
+	id, err := uuid.Parse(dto.ID)
+	if err != nil {
+		return domain.ExportJob{}, err
+	}
+	userID, err := uuid.Parse(dto.UserID)
+	if err != nil {
+		return domain.ExportJob{}, err
+	}
+
+	return domain.ExportJob{
+		ID:            id,
+		UserID:        userID,
+		Status:        dto.Status,
+		Attempts:      dto.Attempts,
+		NextAttemptAt: dto.NextAttemptAt,
+		FileID:        dto.FileID,
+		Size:          dto.Size,
+		LastError:     dto.LastError,
+		CreatedAt:     dto.CreatedAt,
+		FinishedAt:    dto.FinishedAt,
+		ExpiresAt:     dto.ExpiresAt,
+	}, nil
+}
+
+type MongoExportRepo struct {
+	collection *mongo.Collection
+}
+
+func NewMongoExportRepo(db *mongo.Client) *MongoExportRepo {
+	repo := &MongoExportRepo{
+		db.Database("main").Collection("exports"),
+	}
+
+	ctx, cancel := repo.GetContext()
+	defer cancel()
+
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+	})
+	if err != nil {
+		log.Fatalln("Не удалось создать индексы выгрузок", err)
+	}
+
+	return repo
+}
+
+func (m *MongoExportRepo) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Second*10)
+}
+
+func (m *MongoExportRepo) Create(job domain.ExportJob) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.InsertOne(ctx, exportDTO{
+		ID:            job.ID.String(),
+		UserID:        job.UserID.String(),
+		Status:        job.Status,
+		NextAttemptAt: job.NextAttemptAt,
+		CreatedAt:     job.CreatedAt,
+	})
+	return err
+}
+
+func (m *MongoExportRepo) FindByID(id uuid.UUID) (domain.ExportJob, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	var dto exportDTO
+	err := m.collection.FindOne(ctx, bson.M{"id": id.String()}).Decode(&dto)
+	if err != nil {
+		return domain.ExportJob{}, err
+	}
+	return convertDTOToExport(dto)
+}
+
+// FindLatest возвращает последнюю выгрузку пользователя
+func (m *MongoExportRepo) FindLatest(userID uuid.UUID) (domain.ExportJob, bool, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	var dto exportDTO
+	err := m.collection.FindOne(ctx, bson.M{"user_id": userID.String()}, opts).Decode(&dto)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return domain.ExportJob{}, false, nil
+	}
+	if err != nil {
+		return domain.ExportJob{}, false, err
+	}
+
+	job, err := convertDTOToExport(dto)
+	if err != nil {
+		return domain.ExportJob{}, false, err
+	}
+	return job, true, nil
+}
+
+// Claim берет выгрузку в работу на время lease; если сборщик упал, по истечении lease ее возьмет другой
+func (m *MongoExportRepo) Claim(now time.Time, lease time.Duration) (domain.ExportJob, bool, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	filter := bson.M{
+		"status":          bson.M{"$in": []string{domain.ExportPending, domain.ExportRunning}},
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": domain.ExportRunning, "next_attempt_at": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var dto exportDTO
+	err := m.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&dto)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return domain.ExportJob{}, false, nil
+	}
+	if err != nil {
+		return domain.ExportJob{}, false, err
+	}
+
+	job, err := convertDTOToExport(dto)
+	if err != nil {
+		return domain.ExportJob{}, false, err
+	}
+	return job, true, nil
+}
+
+// MarkReady возвращает ErrExportNotFound, если выгрузку уже удалили вместе с аккаунтом
+func (m *MongoExportRepo) MarkReady(id uuid.UUID, fileID string, size int64, expiresAt time.Time) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	result, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String()}, bson.M{"$set": bson.M{
+		"status":      domain.ExportReady,
+		"file_id":     fileID,
+		"size":        size,
+		"last_error":  "",
+		"finished_at": time.Now(),
+		"expires_at":  expiresAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errs.ErrExportNotFound
+	}
+	return nil
+}
+
+func (m *MongoExportRepo) Reschedule(id uuid.UUID, next time.Time, lastError string) error {
+	return m.setState(id, bson.M{"status": domain.ExportPending, "next_attempt_at": next, "last_error": lastError})
+}
+
+func (m *MongoExportRepo) MarkFailed(id uuid.UUID, lastError string) error {
+	return m.setState(id, bson.M{"status": domain.ExportFailed, "last_error": lastError, "finished_at": time.Now()})
+}
+
+// FindExpired возвращает готовые выгрузки, срок хранения которых истек
+func (m *MongoExportRepo) FindExpired(now time.Time) ([]domain.ExportJob, error) {
+	return m.findJobs(bson.M{"status": domain.ExportReady, "expires_at": bson.M{"$lte": now}})
+}
+
+// FindByUser возвращает все выгрузки пользователя в любом статусе
+func (m *MongoExportRepo) FindByUser(userID uuid.UUID) ([]domain.ExportJob, error) {
+	return m.findJobs(bson.M{"user_id": userID.String()})
+}
+
+func (m *MongoExportRepo) findJobs(filter bson.M) ([]domain.ExportJob, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	cursor, err := m.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dtos []exportDTO
+	if err := cursor.All(ctx, &dtos); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]domain.ExportJob, 0, len(dtos))
+	for _, dto := range dtos {
+		job, err := convertDTOToExport(dto)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (m *MongoExportRepo) Delete(id uuid.UUID) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.DeleteOne(ctx, bson.M{"id": id.String()})
+	return err
+}
+
+func (m *MongoExportRepo) setState(id uuid.UUID, fields bson.M) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String()}, bson.M{"$set": fields})
+	return err
+}
+
+// GridFSExportStorage хранит архивы выгрузок в GridFS, чтобы их мог отдать любой экземпляр сервиса
+type GridFSExportStorage struct {
+	bucket *mongo.GridFSBucket
+}
+
+func NewGridFSExportStorage(db *mongo.Client) *GridFSExportStorage {
+	return &GridFSExportStorage{
+		db.Database("main").GridFSBucket(options.GridFSBucket().SetName("exports")),
+	}
+}
+
+func (s *GridFSExportStorage) Save(name string, data io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	id, err := s.bucket.UploadFromStream(ctx, name, data)
+	if err != nil {
+		return "", err
+	}
+	return id.Hex(), nil
+}
+
+// Open открывает архив на чтение; поток читается столько, сколько идет скачивание
+func (s *GridFSExportStorage) Open(fileID string) (io.ReadCloser, error) {
+	id, err := bson.ObjectIDFromHex(fileID)
+	if err != nil {
+		return nil, err
+	}
+	return s.bucket.OpenDownloadStream(context.Background(), id)
+}
+
+func (s *GridFSExportStorage) Delete(fileID string) error {
+	id, err := bson.ObjectIDFromHex(fileID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err = s.bucket.Delete(ctx, id)
+	if errors.Is(err, mongo.ErrFileNotFound) {
+		return nil
+	}
+	return err
+}
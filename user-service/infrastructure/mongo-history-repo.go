@@ -0,0 +1,88 @@
+package infrastructure
+
+import (
+	"auth-kit/mongokit"
+	"context"
+	"errors"
+	"log"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const historyCollection = "user_history"
+
+func newUserHistory(db *mongo.Database) *mongokit.History {
+	return mongokit.NewHistory(db.Collection(historyCollection), "user_id")
+}
+
+// historyRecords переводит записи в общий формат истории; старое и новое значение у пользователя - строки
+func historyRecords(changes []domain.ChangeRecord) []mongokit.ChangeRecord {
+	records := make([]mongokit.ChangeRecord, 0, len(changes))
+	for _, change := range changes {
+		records = append(records, mongokit.ChangeRecord{
+			EntityID:  change.UserID.String(),
+			Field:     change.Field,
+			OldValue:  change.OldValue,
+			NewValue:  change.NewValue,
+			Actor:     change.Actor,
+			Version:   change.Version,
+			ChangedAt: change.ChangedAt,
+		})
+	}
+	return records
+}
+
+type MongoHistoryRepo struct {
+	history *mongokit.History
+}
+
+func NewMongoHistoryRepo(db *mongo.Client) *MongoHistoryRepo {
+	repo := &MongoHistoryRepo{newUserHistory(db.Database("main"))}
+
+	ctx, cancel := repo.GetContext()
+	defer cancel()
+
+	if err := repo.history.CreateIndexes(ctx); err != nil {
+		log.Println("Не удалось создать индексы истории изменений", err)
+	}
+
+	return repo
+}
+
+func (repo *MongoHistoryRepo) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Second*10)
+}
+
+func (repo *MongoHistoryRepo) FindByUser(userID uuid.UUID, before string, limit int) ([]domain.ChangeRecord, error) {
+	ctx, cancel := repo.GetContext()
+	defer cancel()
+
+	found, err := repo.history.Find(ctx, userID.String(), before, limit)
+	if errors.Is(err, mongokit.ErrInvalidHistoryCursor) {
+		return nil, errs.ErrInvalidHistoryCursor
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]domain.ChangeRecord, 0, len(found))
+	for _, record := range found {
+		oldValue, _ := record.OldValue.(string)
+		newValue, _ := record.NewValue.(string)
+		records = append(records, domain.ChangeRecord{
+			ID:        record.ID,
+			UserID:    userID,
+			Field:     record.Field,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			Actor:     record.Actor,
+			Version:   record.Version,
+			ChangedAt: record.ChangedAt,
+		})
+	}
+	return records, nil
+}
@@ -0,0 +1,168 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"user-service/domain"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const outboxCollection = "outbox"
+
+type outboxDTO struct {
+	ID             string         `bson:"id"`
+	Type           string         `bson:"type"`
+	UserID         string         `bson:"user_id"`
+	Payload        map[string]any `bson:"payload"`
+	IdempotencyKey string         `bson:"idempotency_key"`
+	Status         string         `bson:"status"`
+	Attempts       int            `bson:"attempts"`
+	NextAttemptAt  time.Time      `bson:"next_attempt_at"`
+	LastError      string         `bson:"last_error"`
+	CreatedAt      time.Time      `bson:"created_at"`
+}
+
+func outboxDocument(message domain.OutboxMessage) outboxDTO {
+	return outboxDTO{
+		ID:             message.ID.String(),
+		Type:           message.Type,
+		UserID:         message.UserID.String(),
+		Payload:        message.Payload,
+		IdempotencyKey: message.IdempotencyKey,
+		Status:         message.Status,
+		Attempts:       message.Attempts,
+		NextAttemptAt:  message.NextAttemptAt,
+		CreatedAt:      message.CreatedAt,
+	}
+}
+
+func convertDTOToOutbox(dto outboxDTO) (domain.OutboxMessage, error) {
+	id, err := uuid.Parse(dto.ID)
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	userID, err := uuid.Parse(dto.UserID)
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+
+	return domain.OutboxMessage{
+		ID:             id,
+		Type:           dto.Type,
+		UserID:         userID,
+		Payload:        dto.Payload,
+		IdempotencyKey: dto.IdempotencyKey,
+		Status:         dto.Status,
+		Attempts:       dto.Attempts,
+		NextAttemptAt:  dto.NextAttemptAt,
+		LastError:      dto.LastError,
+		CreatedAt:      dto.CreatedAt,
+	}, nil
+}
+
+type MongoOutboxRepo struct {
+	collection *mongo.Collection
+}
+
+func NewMongoOutboxRepo(db *mongo.Client) *MongoOutboxRepo {
+	repo := &MongoOutboxRepo{
+		db.Database("main").Collection(outboxCollection),
+	}
+
+	ctx, cancel := repo.GetContext()
+	defer cancel()
+
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	})
+	// Claim и MarkDelivered находят сообщение по уникальному id
+	if err != nil {
+		log.Fatalln("Не удалось создать индексы outbox", err)
+	}
+
+	return repo
+}
+
+func (m *MongoOutboxRepo) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Second*10)
+}
+
+func (m *MongoOutboxRepo) Claim(now time.Time, lease time.Duration) (domain.OutboxMessage, bool, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	filter := bson.M{"status": domain.OutboxPending, "next_attempt_at": bson.M{"$lte": now}}
+	update := bson.M{
+		"$set": bson.M{"next_attempt_at": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var dto outboxDTO
+	err := m.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&dto)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return domain.OutboxMessage{}, false, nil
+	}
+	if err != nil {
+		return domain.OutboxMessage{}, false, err
+	}
+
+	message, err := convertDTOToOutbox(dto)
+	if err != nil {
+		return domain.OutboxMessage{}, false, err
+	}
+	return message, true, nil
+}
+
+// MarkDelivered закрывает сообщение и стирает payload: в нем лежит хеш пароля, который после доставки не нужен
+func (m *MongoOutboxRepo) MarkDelivered(id uuid.UUID) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String()}, bson.M{
+		"$set":   bson.M{"status": domain.OutboxDelivered, "last_error": ""},
+		"$unset": bson.M{"payload": ""},
+	})
+	return err
+}
+
+func (m *MongoOutboxRepo) Reschedule(id uuid.UUID, next time.Time, lastError string) error {
+	return m.setState(id, bson.M{"next_attempt_at": next, "last_error": lastError})
+}
+
+func (m *MongoOutboxRepo) DeadLetter(id uuid.UUID, lastError string) error {
+	return m.setState(id, bson.M{"status": domain.OutboxDead, "last_error": lastError})
+}
+
+func (m *MongoOutboxRepo) setState(id uuid.UUID, fields bson.M) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String()}, bson.M{"$set": fields})
+	return err
+}
+
+func (m *MongoOutboxRepo) DeleteByUser(userID uuid.UUID) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.DeleteMany(ctx, bson.M{"user_id": userID.String()})
+	return err
+}
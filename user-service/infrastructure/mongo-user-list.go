@@ -0,0 +1,151 @@
+package infrastructure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"regexp"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// listCursor - последний пользователь страницы; следующая страница начинается строго после него.
+// Логин и email уникальны, created_at совпадает у разных пользователей, поэтому к нему добавляется id
+type listCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+func encodeListCursor(query domain.UserListQuery, last UserDTO) string {
+	cursor := listCursor{SortBy: query.SortBy, Desc: query.Desc, ID: last.ID}
+	switch query.SortBy {
+	case domain.SortByCreatedAt:
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case domain.SortByLogin:
+		cursor.Value = last.Login
+	case domain.SortByEmail:
+		cursor.Value = last.Email
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cursorFilter отсекает пользователей до курсора; курсор от другой сортировки не принимается
+func cursorFilter(query domain.UserListQuery) (bson.M, error) {
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, errs.ErrInvalidListCursor
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errs.ErrInvalidListCursor
+	}
+	if cursor.SortBy != query.SortBy || cursor.Desc != query.Desc {
+		return nil, errs.ErrInvalidListCursor
+	}
+
+	op := "$gt"
+	if query.Desc {
+		op = "$lt"
+	}
+
+	if query.SortBy != domain.SortByCreatedAt {
+		return bson.M{query.SortBy: bson.M{op: cursor.Value}}, nil
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+	if err != nil {
+		return nil, errs.ErrInvalidListCursor
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"created_at": bson.M{op: createdAt}},
+		bson.M{"created_at": createdAt, "id": bson.M{op: cursor.ID}},
+	}}, nil
+}
+
+func (m *MongoUserRepo) List(query domain.UserListQuery) (domain.UserPage, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	conditions := bson.A{}
+	if query.Search != "" {
+		// якорный префикс без флагов обслуживается уникальными индексами полей
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(query.Search)}
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"login": prefix},
+			bson.M{"email": prefix},
+			bson.M{"phone_number": prefix},
+		}})
+	}
+	if query.EmailVerified != nil {
+		conditions = append(conditions, bson.M{"email_verified": *query.EmailVerified})
+	}
+	if query.PhoneVerified != nil {
+		conditions = append(conditions, bson.M{"phone_verified": *query.PhoneVerified})
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$gte": query.CreatedFrom}})
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$lt": query.CreatedTo}})
+	}
+	if query.Cursor != "" {
+		after, err := cursorFilter(query)
+		if err != nil {
+			return domain.UserPage{}, err
+		}
+		conditions = append(conditions, after)
+	}
+
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+
+	direction := 1
+	if query.Desc {
+		direction = -1
+	}
+	sort := bson.D{{Key: query.SortBy, Value: direction}}
+	if query.SortBy == domain.SortByCreatedAt {
+		sort = append(sort, bson.E{Key: "id", Value: direction})
+	}
+
+	// лишний документ показывает, есть ли следующая страница
+	opts := options.Find().SetSort(sort).SetLimit(int64(query.Limit + 1))
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return domain.UserPage{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var dtos []UserDTO
+	if err := cursor.All(ctx, &dtos); err != nil {
+		return domain.UserPage{}, err
+	}
+
+	hasMore := len(dtos) > query.Limit
+	if hasMore {
+		dtos = dtos[:query.Limit]
+	}
+
+	page := domain.UserPage{Users: make([]domain.User, 0, len(dtos))}
+	for _, dto := range dtos {
+		user, err := convertDTOToUser(dto)
+		if err != nil {
+			log.Println("Не удалось разобрать пользователя из списка", dto.ID, err)
+			continue
+		}
+		page.Users = append(page.Users, user)
+	}
+	if hasMore {
+		page.NextCursor = encodeListCursor(query, dtos[len(dtos)-1])
+	}
+	return page, nil
+}
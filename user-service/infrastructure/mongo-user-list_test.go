@@ -0,0 +1,152 @@
+package infrastructure
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// afterCursor применяет к dto фильтр, который cursorFilter строит для сортировки по created_at
+func afterCursor(t *testing.T, filter bson.M, dto UserDTO) bool {
+	t.Helper()
+	alternatives, ok := filter["$or"].(bson.A)
+	if !ok || len(alternatives) != 2 {
+		t.Fatalf("неожиданный фильтр %v", filter)
+	}
+
+	compare := func(condition bson.M, actual int) bool {
+		if _, ok := condition["$gt"]; ok {
+			return actual > 0
+		}
+		return actual < 0
+	}
+
+	earlier := alternatives[0].(bson.M)["created_at"].(bson.M)
+	var bound time.Time
+	for _, value := range earlier {
+		bound = value.(time.Time)
+	}
+	if compare(earlier, dto.CreatedAt.Compare(bound)) {
+		return true
+	}
+
+	tie := alternatives[1].(bson.M)
+	if !tie["created_at"].(time.Time).Equal(dto.CreatedAt) {
+		return false
+	}
+	idCondition := tie["id"].(bson.M)
+	var afterID string
+	for _, value := range idCondition {
+		afterID = value.(string)
+	}
+	return compare(idCondition, strings.Compare(dto.ID, afterID))
+}
+
+func TestListCursorRoundTripWithDuplicateCreatedAt(t *testing.T) {
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var users []UserDTO
+	for i, offset := range []int{0, 0, 0, 1, 1, 2, 3, 3, 3, 3} {
+		users = append(users, UserDTO{
+			ID:        string(rune('a' + (i*7)%10)),
+			CreatedAt: base.Add(time.Duration(offset) * time.Millisecond),
+		})
+	}
+
+	tests := []struct {
+		name  string
+		desc  bool
+		limit int
+	}{
+		{"по возрастанию по одному", false, 1},
+		{"по возрастанию по два", false, 2},
+		{"по возрастанию по три", false, 3},
+		{"по убыванию по одному", true, 1},
+		{"по убыванию по два", true, 2},
+		{"по убыванию по четыре", true, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// порядок, в котором их отдает Mongo: created_at, затем id
+			sorted := append([]UserDTO(nil), users...)
+			sort.Slice(sorted, func(i, j int) bool {
+				if c := sorted[i].CreatedAt.Compare(sorted[j].CreatedAt); c != 0 {
+					return (c < 0) != tt.desc
+				}
+				return (sorted[i].ID < sorted[j].ID) != tt.desc
+			})
+
+			query := domain.UserListQuery{SortBy: domain.SortByCreatedAt, Desc: tt.desc, Limit: tt.limit}
+			var seen []string
+			remaining := sorted
+			for len(remaining) > 0 {
+				if query.Cursor != "" {
+					filter, err := cursorFilter(query)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var next []UserDTO
+					for _, dto := range remaining {
+						if afterCursor(t, filter, dto) {
+							next = append(next, dto)
+						}
+					}
+					remaining = next
+				}
+
+				// если курсор не двигается, страницы будут повторяться бесконечно
+				if len(seen) > len(sorted) {
+					t.Fatalf("курсор не продвигается: %v", seen)
+				}
+				page := remaining[:min(tt.limit, len(remaining))]
+				for _, dto := range page {
+					seen = append(seen, dto.ID)
+				}
+				if len(remaining) <= tt.limit {
+					break
+				}
+				query.Cursor = encodeListCursor(query, page[len(page)-1])
+				remaining = sorted
+			}
+
+			var want []string
+			for _, dto := range sorted {
+				want = append(want, dto.ID)
+			}
+			if strings.Join(seen, ",") != strings.Join(want, ",") {
+				t.Errorf("страницы вернули %v, ожидалось %v", seen, want)
+			}
+		})
+	}
+}
+
+func TestCursorFilterRejectsForeignCursor(t *testing.T) {
+	last := UserDTO{ID: "a", Login: "alice", CreatedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+	byCreatedAt := domain.UserListQuery{SortBy: domain.SortByCreatedAt}
+	cursor := encodeListCursor(byCreatedAt, last)
+
+	tests := []struct {
+		name  string
+		query domain.UserListQuery
+	}{
+		{"другое поле сортировки", domain.UserListQuery{SortBy: domain.SortByLogin, Cursor: cursor}},
+		{"другое направление", domain.UserListQuery{SortBy: domain.SortByCreatedAt, Desc: true, Cursor: cursor}},
+		{"не base64", domain.UserListQuery{SortBy: domain.SortByCreatedAt, Cursor: "!!!"}},
+		{"не JSON", domain.UserListQuery{SortBy: domain.SortByCreatedAt, Cursor: base64.RawURLEncoding.EncodeToString([]byte("курсор"))}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := cursorFilter(tt.query); !errors.Is(err, errs.ErrInvalidListCursor) {
+				t.Errorf("cursorFilter = %v, ожидалась ErrInvalidListCursor", err)
+			}
+		})
+	}
+}
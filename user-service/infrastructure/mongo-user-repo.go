@@ -1,24 +1,33 @@
 package infrastructure
 
 import (
+	"auth-kit/mongokit"
 	"context"
 	"log"
+	"strings"
 	"time"
 	"user-service/domain"
+	errs "user-service/errors"
 	"user-service/valueObjects"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 // UserDTO - DTO для MongoDB
 type UserDTO struct {
-	ID           string `bson:"id"`
-	Login        string `bson:"login"`
-	Email        string `bson:"email"`
-	PhoneNumber  string `bson:"phone_number"`
-	PasswordHash string `bson:"password_hash"`
+	ID            string    `bson:"id"`
+	Login         string    `bson:"login"`
+	Email         string    `bson:"email"`
+	PhoneNumber   string    `bson:"phone_number"`
+	EmailVerified bool      `bson:"email_verified"`
+	PhoneVerified bool      `bson:"phone_verified"`
+	Status        string    `bson:"status"`
+	PurgeAt       time.Time `bson:"purge_at,omitempty"`
+	Version       int64     `bson:"version"`
+	CreatedAt     time.Time `bson:"created_at"`
 }
 
 // convertDTOToUser - конвертирует UserDTO в domain.User
@@ -45,18 +54,24 @@ func convertDTOToUser(dto UserDTO) (domain.User, error) {
 		return domain.User{}, err
 	}
 
-	passwordVO, err := valueObjects.NewPassword(dto.PasswordHash)
-	if err != nil {
-		return domain.User{}, err
+	// пользователи, созданные до появления статуса, уже активны
+	status := dto.Status
+	if status == "" {
+		status = domain.UserStatusActive
 	}
 
 	// Создаем domain.User
 	user := domain.User{
-		ID:           userID,
-		Login:        loginVO,
-		Email:        emailVO,
-		PhoneNumber:  phoneVO,
-		PasswordHash: passwordVO,
+		ID:            userID,
+		Login:         loginVO,
+		Email:         emailVO,
+		PhoneNumber:   phoneVO,
+		EmailVerified: dto.EmailVerified,
+		PhoneVerified: dto.PhoneVerified,
+		Status:        status,
+		PurgeAt:       dto.PurgeAt,
+		Version:       dto.Version,
+		CreatedAt:     dto.CreatedAt,
 	}
 
 	return user, nil
@@ -64,68 +79,328 @@ func convertDTOToUser(dto UserDTO) (domain.User, error) {
 
 type MongoUserRepo struct {
 	collection *mongo.Collection
+	history    *mongokit.History
 }
 
+// имена уникальных индексов; по ним ошибка дубликата переводится в доменную
+const (
+	loginIndex = "login_unique"
+	emailIndex = "email_unique"
+	phoneIndex = "phone_number_unique"
+)
+
 func NewMongoRepo(db *mongo.Client) *MongoUserRepo {
-	return &MongoUserRepo{
+	repo := &MongoUserRepo{
 		db.Database("main").Collection("users"),
+		newUserHistory(db.Database("main")),
 	}
+
+	ctx, cancel := repo.GetContext()
+	defer cancel()
+
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("id_unique"),
+		},
+		{
+			Keys:    bson.D{{Key: "login", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName(loginIndex),
+		},
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName(emailIndex),
+		},
+		{
+			Keys:    bson.D{{Key: "phone_number", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName(phoneIndex),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "purge_at", Value: 1}},
+			Options: options.Index().SetName("purge_due"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}},
+			Options: options.Index().SetName("created_at_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "email_verified", Value: 1}, {Key: "phone_verified", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("verified_created_at"),
+		},
+	})
+	// занятость логина, email и телефона держится только на уникальных индексах
+	if err != nil {
+		log.Fatalln("Не удалось создать индексы пользователей", err)
+	}
+
+	// у пользователей, созданных до появления created_at, дата берется из _id
+	_, err = repo.collection.UpdateMany(ctx, bson.M{"created_at": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "created_at", Value: bson.D{{Key: "$toDate", Value: "$_id"}}}}}},
+	})
+	if err != nil {
+		log.Println("Не удалось заполнить дату регистрации пользователей", err)
+	}
+
+	// хеш пароля хранит только auth-service, копия в базе устаревала после сброса пароля
+	_, err = repo.collection.UpdateMany(ctx, bson.M{"password_hash": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"password_hash": ""}})
+	if err != nil {
+		log.Println("Не удалось удалить хеши паролей пользователей", err)
+	}
+
+	return repo
+}
+
+// duplicateKeyError переводит нарушение уникального индекса в ошибку о занятом логине, email или телефоне
+func duplicateKeyError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, loginIndex):
+		return errs.ErrLoginAlreadyExists
+	case strings.Contains(message, emailIndex):
+		return errs.ErrEmailAlreadyExists
+	case strings.Contains(message, phoneIndex):
+		return errs.ErrPhoneAlreadyExists
+	}
+	return err
 }
 
 func (m *MongoUserRepo) GetContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), time.Second*10)
 }
 
+func userDocument(user domain.User) bson.M {
+	return bson.M{
+		"id":             user.ID.String(),
+		"login":          user.Login.String(),
+		"email":          user.Email.String(),
+		"phone_number":   user.PhoneNumber.String(),
+		"email_verified": false,
+		"phone_verified": false,
+		"status":         user.Status,
+		"version":        1,
+		"created_at":     user.CreatedAt,
+	}
+}
+
 func (m *MongoUserRepo) Create(user domain.User) error {
 	ctx, cancel := m.GetContext()
 	defer cancel()
 
-	userDoc := bson.M{
-		"id":            user.ID.String(),
-		"login":         user.Login.String(),
-		"email":         user.Email.String(),
-		"phone_number":  user.PhoneNumber.String(),
-		"password_hash": user.PasswordHash.String(),
+	_, err := m.collection.InsertOne(ctx, userDocument(user))
+	return duplicateKeyError(err)
+}
+
+// CreateWithOutbox записывает пользователя и сообщение outbox в одной транзакции (см. RequireTransactions)
+func (m *MongoUserRepo) CreateWithOutbox(user domain.User, message domain.OutboxMessage) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	session, err := m.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
 	}
+	defer session.EndSession(ctx)
+
+	outbox := m.collection.Database().Collection(outboxCollection)
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		if _, err := m.collection.InsertOne(ctx, userDocument(user)); err != nil {
+			return nil, err
+		}
+		return outbox.InsertOne(ctx, outboxDocument(message))
+	})
+	return duplicateKeyError(err)
+}
 
-	_, err := m.collection.InsertOne(ctx, userDoc)
-	return err
+func (m *MongoUserRepo) SetStatus(id uuid.UUID, from, to string) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	result, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String(), "status": from}, bson.M{
+		"$set": bson.M{"status": to},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// FailRegistration освобождает логин, email и телефон не сразу, а через AccountPurger:
+// он же удалит аккаунт, если auth-service успел его создать
+func (m *MongoUserRepo) FailRegistration(id uuid.UUID, purgeAt time.Time) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	filter := bson.M{"id": id.String(), "status": domain.UserStatusPending}
+	result, err := m.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"status": domain.UserStatusFailed, "purge_at": purgeAt},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
 }
 
-func (m *MongoUserRepo) Update(id uuid.UUID, update domain.UserUpdate) error {
+// Update применяет изменения, только если пользователь все еще в версии expectedVersion.
+// Изменение и записи истории пишутся в одной транзакции
+func (m *MongoUserRepo) Update(id uuid.UUID, update domain.UserUpdate, expectedVersion int64, changes []domain.ChangeRecord) error {
 	ctx, cancel := m.GetContext()
 	defer cancel()
 
 	changed := bson.M{}
 	for k, v := range update.FieldsToUpdate {
-		log.Printf("обновляется поле %s на знеачение %s\n", k, v)
+		// пароль меняется только в auth-service, в базу попадает лишь запись истории
+		if k == domain.FieldPassword {
+			continue
+		}
 		changed[k] = v
 	}
-	updateBson := bson.M{"$set": changed}
-
-	for k, v := range changed {
-		log.Printf("key %s value %s\n", k, v)
+	// новый email или телефон нужно подтверждать заново
+	if _, ok := update.FieldsToUpdate[domain.FieldEmail]; ok {
+		changed["email_verified"] = false
 	}
-
-	for k, v := range updateBson {
-		log.Printf("ket %s value %s", k, v)
+	if _, ok := update.FieldsToUpdate[domain.FieldPhone]; ok {
+		changed["phone_verified"] = false
 	}
+	updateBson := bson.M{"$set": changed, "$inc": bson.M{"version": 1}}
 
 	log.Println("обновляем документ с id:", id)
 
-	result, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String()}, updateBson)
-	log.Printf("Найдено документов %d, обновлено %d\n", result.MatchedCount, result.ModifiedCount)
-	return err
+	session, err := m.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	filter := bson.M{"id": id.String(), "version": mongokit.VersionFilter(expectedVersion)}
+	matched, err := session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		result, err := m.collection.UpdateOne(ctx, filter, updateBson)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Найдено документов %d, обновлено %d\n", result.MatchedCount, result.ModifiedCount)
+		if result.MatchedCount == 0 || len(changes) == 0 {
+			return result.MatchedCount > 0, nil
+		}
+		if err := m.history.Insert(ctx, historyRecords(changes)); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return duplicateKeyError(err)
+	}
+	if !matched.(bool) {
+		exists, err := m.existsByField("id", id.String())
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return mongo.ErrNoDocuments
+		}
+		return errs.ErrVersionConflict
+	}
+	return nil
 }
 
+// Delete стирает пользователя вместе с историей изменений, в которой остались его прежние данные
 func (m *MongoUserRepo) Delete(id uuid.UUID) error {
 	ctx, cancel := m.GetContext()
 	defer cancel()
 
+	if err := m.history.DeleteByEntity(ctx, id.String()); err != nil {
+		return err
+	}
 	_, err := m.collection.DeleteOne(ctx, bson.M{"id": id.String()})
 	return err
 }
 
+// ScheduleDeletion скрывает пользователя до окончательного удаления в purgeAt
+func (m *MongoUserRepo) ScheduleDeletion(id uuid.UUID, purgeAt time.Time) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	result, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String()}, bson.M{"$set": bson.M{
+		"status":   domain.UserStatusDeletionSchedule,
+		"purge_at": purgeAt,
+	}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Restore возвращает деактивированного или ожидающего удаления пользователя в active
+func (m *MongoUserRepo) Restore(id uuid.UUID) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	filter := bson.M{
+		"id":     id.String(),
+		"status": bson.M{"$in": []string{domain.UserStatusDeactivated, domain.UserStatusDeletionSchedule}},
+	}
+	result, err := m.collection.UpdateOne(ctx, filter, bson.M{
+		"$set":   bson.M{"status": domain.UserStatusActive},
+		"$unset": bson.M{"purge_at": ""},
+		"$inc":   bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// FindDueForPurge возвращает пользователей, у которых истек срок восстановления,
+// и неудавшиеся регистрации
+func (m *MongoUserRepo) FindDueForPurge(now time.Time, limit int) ([]domain.User, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	filter := bson.M{
+		"status":   bson.M{"$in": []string{domain.UserStatusDeletionSchedule, domain.UserStatusFailed}},
+		"purge_at": bson.M{"$lte": now},
+	}
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dtos []UserDTO
+	if err := cursor.All(ctx, &dtos); err != nil {
+		return nil, err
+	}
+
+	users := make([]domain.User, 0, len(dtos))
+	for _, dto := range dtos {
+		user, err := convertDTOToUser(dto)
+		if err != nil {
+			log.Println("Не удалось разобрать пользователя на удаление", dto.ID, err)
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
 func (m *MongoUserRepo) FindByID(id uuid.UUID) (domain.User, error) {
 	ctx, cancel := m.GetContext()
 	defer cancel()
@@ -136,25 +411,32 @@ func (m *MongoUserRepo) FindByID(id uuid.UUID) (domain.User, error) {
 		return domain.User{}, err
 	}
 
-	log.Printf("Найден пользователь DTO: %+v\n", userDTO)
-
 	// Конвертируем DTO в domain.User
 	user, err := convertDTOToUser(userDTO)
 	if err != nil {
 		return domain.User{}, err
 	}
 
-	log.Println("\n\nКонвертированный пользователь:", user)
-
 	return user, nil
 }
 
 func (m *MongoUserRepo) FindByLogin(login string) (domain.User, error) {
+	return m.FindByCredential("login", login)
+}
+
+// FindByCredential ищет пользователя по логину, email или телефону - как при входе в auth-service
+func (m *MongoUserRepo) FindByCredential(credType, value string) (domain.User, error) {
 	ctx, cancel := m.GetContext()
 	defer cancel()
 
+	fields := map[string]string{"login": "login", "email": "email", "phone": "phone_number"}
+	field, ok := fields[credType]
+	if !ok {
+		return domain.User{}, errs.ErrInvalidCredType
+	}
+
 	var userDTO UserDTO
-	err := m.collection.FindOne(ctx, bson.M{"login": login}).Decode(&userDTO)
+	err := m.collection.FindOne(ctx, bson.M{field: value}).Decode(&userDTO)
 	if err != nil {
 		return domain.User{}, err
 	}
@@ -187,3 +469,27 @@ func (m *MongoUserRepo) existsByField(field, value string) (bool, error) {
 	count, err := m.collection.CountDocuments(ctx, bson.M{field: value})
 	return count > 0, err
 }
+
+// SetVerified меняет флаг, только пока у пользователя тот же destination:
+// смена email или телефона после отправки кода не подтверждается старым кодом
+func (m *MongoUserRepo) SetVerified(id uuid.UUID, channel domain.VerificationChannel, destination string, verified bool) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	field := string(channel) + "_verified"
+	destinationField := "email"
+	if channel == domain.ChannelPhone {
+		destinationField = "phone_number"
+	}
+	result, err := m.collection.UpdateOne(ctx, bson.M{"id": id.String(), destinationField: destination}, bson.M{
+		"$set": bson.M{field: verified},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package infrastructure
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-service/domain"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type verificationCodeDTO struct {
+	UserID      string    `bson:"user_id"`
+	Channel     string    `bson:"channel"`
+	Destination string    `bson:"destination"`
+	CodeHash    string    `bson:"code_hash"`
+	Attempts    int       `bson:"attempts"`
+	CreatedAt   time.Time `bson:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type MongoVerificationRepo struct {
+	collection *mongo.Collection
+}
+
+func NewMongoVerificationRepo(db *mongo.Client) *MongoVerificationRepo {
+	repo := &MongoVerificationRepo{
+		db.Database("main").Collection("verification_codes"),
+	}
+
+	ctx, cancel := repo.GetContext()
+	defer cancel()
+
+	// просроченные коды удаляет сама MongoDB
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "channel", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	// Save рассчитывает на один код на канал
+	if err != nil {
+		log.Fatalln("Не удалось создать индексы кодов подтверждения", err)
+	}
+
+	return repo
+}
+
+func (m *MongoVerificationRepo) GetContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Second*10)
+}
+
+func verificationFilter(userID uuid.UUID, channel domain.VerificationChannel) bson.M {
+	return bson.M{"user_id": userID.String(), "channel": string(channel)}
+}
+
+// Save заменяет предыдущий код того же канала
+func (m *MongoVerificationRepo) Save(code domain.VerificationCode) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	doc := verificationCodeDTO{
+		UserID:      code.UserID.String(),
+		Channel:     string(code.Channel),
+		Destination: code.Destination,
+		CodeHash:    code.CodeHash,
+		Attempts:    code.Attempts,
+		CreatedAt:   code.CreatedAt,
+		ExpiresAt:   code.ExpiresAt,
+	}
+
+	_, err := m.collection.ReplaceOne(ctx, verificationFilter(code.UserID, code.Channel), doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *MongoVerificationRepo) Find(userID uuid.UUID, channel domain.VerificationChannel) (domain.VerificationCode, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	var dto verificationCodeDTO
+	err := m.collection.FindOne(ctx, verificationFilter(userID, channel)).Decode(&dto)
+	if err != nil {
+		return domain.VerificationCode{}, err
+	}
+
+	return domain.VerificationCode{
+		UserID:      userID,
+		Channel:     channel,
+		Destination: dto.Destination,
+		CodeHash:    dto.CodeHash,
+		Attempts:    dto.Attempts,
+		CreatedAt:   dto.CreatedAt,
+		ExpiresAt:   dto.ExpiresAt,
+	}, nil
+}
+
+func (m *MongoVerificationRepo) IncrementAttempts(userID uuid.UUID, channel domain.VerificationChannel) (int, error) {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	var dto verificationCodeDTO
+	err := m.collection.FindOneAndUpdate(ctx,
+		verificationFilter(userID, channel),
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&dto)
+	if err != nil {
+		return 0, err
+	}
+	return dto.Attempts, nil
+}
+
+func (m *MongoVerificationRepo) Delete(userID uuid.UUID, channel domain.VerificationChannel) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.DeleteOne(ctx, verificationFilter(userID, channel))
+	return err
+}
+
+func (m *MongoVerificationRepo) DeleteByUser(userID uuid.UUID) error {
+	ctx, cancel := m.GetContext()
+	defer cancel()
+
+	_, err := m.collection.DeleteMany(ctx, bson.M{"user_id": userID.String()})
+	return err
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"auth-kit"
+	"auth-kit/mongokit"
+	"context"
 	"log"
 	"os"
+	"time"
 	"user-service/config"
 	"user-service/infrastructure"
 	"user-service/service"
 	"user-service/transport"
+	"user-service/valueObjects"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -25,11 +30,65 @@ func main() {
 	}
 	log.Println("Подключение к БД произошло успешно")
 
+	// регистрация и обновление пользователя пишутся в транзакциях, без них сервис не запускается
+	if err := mongokit.RequireTransactions(db); err != nil {
+		log.Println("База данных не поддерживает транзакции", err)
+		return
+	}
+
+	hasher, err := authkit.PasswordHasherFromEnv()
+	if err != nil {
+		log.Println("Некорректные настройки хеширования паролей", err)
+		return
+	}
+	valueObjects.SetPasswordHasher(hasher)
+
 	repo := infrastructure.NewMongoRepo(db)
-	service := service.NewUserService(repo)
-	handler := transport.NewUserHandler(service)
+	codes := infrastructure.NewMongoVerificationRepo(db)
+
+	emailSender, smsSender, err := authkit.SendersFromEnv()
+	if err != nil {
+		log.Println("Не удалось настроить отправку кодов", err)
+		return
+	}
+
+	authClient, err := authkit.InternalClientFromEnv("user-service")
+	if err != nil {
+		log.Println("Не удалось настроить подпись запросов к auth-service", err)
+		return
+	}
+
+	anketasClient := authClient.For(config.GetEnv("ANKETAS_SERVICE_URL", "http://127.0.0.1:8004"))
+	messagesClient := authClient.For(config.GetEnv("MESSAGES_SERVICE_URL", "http://127.0.0.1:8005"))
+
+	// регистрации доставляются в auth-service в фоне, с повторами
+	outbox := infrastructure.NewMongoOutboxRepo(db)
+	dispatcher := service.NewOutboxDispatcher(outbox, repo, authClient)
+	go dispatcher.Run(context.Background())
+
+	// выгрузки персональных данных собираются в фоне и хранятся EXPORT_TTL
+	exportRepo := infrastructure.NewMongoExportRepo(db)
+	exportStorage := infrastructure.NewGridFSExportStorage(db)
+	exportTTL := config.GetDuration("EXPORT_TTL", 7*24*time.Hour)
+	exportWorker := service.NewExportWorker(exportRepo, exportStorage, repo, authClient, anketasClient, messagesClient, exportTTL)
+	go exportWorker.Run(context.Background())
+	exports := service.NewExportService(repo, exportRepo, exportStorage)
+
+	// удаленные аккаунты стираются окончательно после срока восстановления
+	purger := service.NewAccountPurger(repo, outbox, codes, exportRepo, exportStorage, authClient, anketasClient, messagesClient)
+	go purger.Run(context.Background())
+
+	deletionGrace := config.GetDuration("USER_DELETION_GRACE", 30*24*time.Hour)
+	service := service.NewUserService(repo, infrastructure.NewMongoHistoryRepo(db), codes, emailSender, smsSender, authClient, anketasClient, deletionGrace)
+	auth := authkit.NewAuthenticator(authkit.ConfigFromEnv())
+	handler := transport.NewUserHandler(service, exports, auth)
 
 	r := gin.Default()
+	// адрес клиента из X-Forwarded-For принимается только от балансировщика перед сервисом
+	if err := r.SetTrustedProxies(authkit.TrustedProxiesFromEnv()); err != nil {
+		log.Println("Некорректное значение TRUSTED_PROXIES", err)
+		return
+	}
 	handler.RegisterRoutes(r)
 	log.Println("gin проининциализирован")
 	err = r.Run("127.0.0.1:8080")
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"auth-kit"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Деактивация и удаление сначала закрывают вход в auth-service, затем меняют статус
+// в базе и скрывают анкету. Окончательно данные стирает AccountPurger после deletionGrace
+
+// Deactivate скрывает аккаунт до восстановления
+func (s UserServiceImpl) Deactivate(id uuid.UUID) error {
+	user, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+
+	switch user.Status {
+	case domain.UserStatusActive:
+	case domain.UserStatusDeactivated, domain.UserStatusDeletionSchedule:
+		return errs.ErrAlreadyDeactivated
+	default:
+		return errs.ErrUserNotActive
+	}
+
+	anketaId, err := setAccountDisabled(s.authClient, id, true)
+	if err != nil {
+		return err
+	}
+
+	// статус меняется только у активного пользователя, иначе параллельное удаление
+	// потеряло бы purge_at. Вход в этом случае уже закрыт удалением, откатывать его нельзя
+	err = s.repo.SetStatus(id, domain.UserStatusActive, domain.UserStatusDeactivated)
+	if err == mongo.ErrNoDocuments {
+		return errs.ErrAlreadyDeactivated
+	}
+	if err != nil {
+		if _, rollbackErr := setAccountDisabled(s.authClient, id, false); rollbackErr != nil {
+			log.Println("Не удалось вернуть вход в auth-service для", id, rollbackErr)
+		}
+		return err
+	}
+
+	s.setAnketaHidden(anketaId, true)
+	return nil
+}
+
+// Delete планирует удаление аккаунта; до возвращенного момента его можно восстановить
+func (s UserServiceImpl) Delete(id uuid.UUID) (time.Time, error) {
+	user, err := s.findUser(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if user.Status == domain.UserStatusDeletionSchedule {
+		return user.PurgeAt, nil
+	}
+
+	anketaId, err := setAccountDisabled(s.authClient, id, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	purgeAt := time.Now().Add(s.deletionGrace)
+	if err := s.repo.ScheduleDeletion(id, purgeAt); err != nil {
+		if user.IsActive() {
+			if _, rollbackErr := setAccountDisabled(s.authClient, id, false); rollbackErr != nil {
+				log.Println("Не удалось вернуть вход в auth-service для", id, rollbackErr)
+			}
+		}
+		return time.Time{}, err
+	}
+
+	s.setAnketaHidden(anketaId, true)
+	return purgeAt, nil
+}
+
+// Restore восстанавливает аккаунт по тем же данным, что и вход, и сразу выполняет вход.
+// Пароль проверяет auth-service: ответ account_disabled приходит только после верного пароля
+func (s UserServiceImpl) Restore(credType, identifier, password string, client domain.ClientInfo) (domain.AuthResponse, error) {
+	user, err := s.repo.FindByCredential(credType, identifier)
+	if err == errs.ErrInvalidCredType {
+		return domain.AuthResponse{}, err
+	}
+
+	resp, loginErr := s.authLogin(credType, identifier, password, client)
+	if loginErr != nil || err != nil || !user.IsRestorable() {
+		return resp, loginErr
+	}
+
+	confirmed := resp.StatusCode == http.StatusOK
+	if resp.StatusCode == http.StatusForbidden {
+		var body struct {
+			AccountDisabled bool `json:"account_disabled"`
+		}
+		confirmed = json.Unmarshal(resp.Body, &body) == nil && body.AccountDisabled
+	}
+	if !confirmed {
+		return resp, nil
+	}
+
+	if err := s.RestoreByID(user.ID); err != nil {
+		return domain.AuthResponse{}, err
+	}
+
+	return s.authLogin(credType, identifier, password, client)
+}
+
+// RestoreByID возвращает аккаунт в active; используется и поддержкой
+func (s UserServiceImpl) RestoreByID(id uuid.UUID) error {
+	user, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+	if !user.IsRestorable() {
+		return errs.ErrNotRestorable
+	}
+
+	anketaId, err := setAccountDisabled(s.authClient, id, false)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Restore(id); err != nil {
+		if _, rollbackErr := setAccountDisabled(s.authClient, id, true); rollbackErr != nil {
+			log.Println("Не удалось снова закрыть вход в auth-service для", id, rollbackErr)
+		}
+		if err == mongo.ErrNoDocuments {
+			return errs.ErrNotRestorable
+		}
+		return err
+	}
+
+	s.setAnketaHidden(anketaId, false)
+	return nil
+}
+
+func (s UserServiceImpl) findUser(id uuid.UUID) (domain.User, error) {
+	user, err := s.repo.FindByID(id)
+	if err == mongo.ErrNoDocuments {
+		return domain.User{}, errs.ErrUserNotFound
+	}
+	return user, err
+}
+
+// setAnketaHidden меняет видимость анкеты; ошибка не отменяет смену статуса аккаунта
+func (s UserServiceImpl) setAnketaHidden(anketaId string, hidden bool) {
+	if anketaId == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := s.anketasClient.PostJSON(ctx, "/internal/anketa/hidden", map[string]any{
+		"anketa_id": anketaId,
+		"hidden":    hidden,
+	})
+	if err != nil {
+		log.Println("Не удалось изменить видимость анкеты", anketaId, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Println("anketas-service не изменил видимость анкеты", anketaId, "статус", resp.StatusCode)
+	}
+}
+
+// setAccountDisabled закрывает или открывает вход в auth-service и возвращает anketa_id аккаунта.
+// Отсутствующий аккаунт (регистрация не дошла до auth-service) не считается ошибкой
+func setAccountDisabled(authClient *authkit.InternalClient, id uuid.UUID, disabled bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := authClient.PostJSON(ctx, "/setAccountDisabled", map[string]any{
+		"user_id":  id.String(),
+		"disabled": disabled,
+	})
+	if err != nil {
+		log.Println("Не удалось изменить статус аккаунта в auth-service", err)
+		return "", errs.ErrAccountSyncFailed
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var result struct {
+			AnketaId string `json:"anketa_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return "", errs.ErrAccountSyncFailed
+		}
+		return result.AnketaId, nil
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		log.Println("auth-service отклонил изменение статуса аккаунта, статус", resp.StatusCode)
+		return "", errs.ErrAccountSyncFailed
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"auth-kit"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"user-service/domain"
+
+	"github.com/google/uuid"
+)
+
+// AccountPurger окончательно удаляет пользователей, у которых истек срок восстановления,
+// и неудавшиеся регистрации:
+// анкету с фотографиями, переписку, аккаунт в auth-service, сообщения outbox, коды подтверждения,
+// выгрузки с архивами и запись в базе. Каждый шаг
+// можно повторить, поэтому при ошибке пользователь просто попадет в следующий проход
+type AccountPurger struct {
+	users          domain.UserRepo
+	outbox         domain.OutboxRepo
+	codes          domain.VerificationRepo
+	exports        domain.ExportRepo
+	exportStorage  domain.ExportStorage
+	authClient     *authkit.InternalClient
+	anketasClient  *authkit.InternalClient
+	messagesClient *authkit.InternalClient
+	batchSize      int
+	pollInterval   time.Duration
+}
+
+func NewAccountPurger(users domain.UserRepo, outbox domain.OutboxRepo, codes domain.VerificationRepo,
+	exports domain.ExportRepo, exportStorage domain.ExportStorage,
+	authClient, anketasClient, messagesClient *authkit.InternalClient) *AccountPurger {
+	return &AccountPurger{
+		users:          users,
+		outbox:         outbox,
+		codes:          codes,
+		exports:        exports,
+		exportStorage:  exportStorage,
+		authClient:     authClient,
+		anketasClient:  anketasClient,
+		messagesClient: messagesClient,
+		batchSize:      50,
+		pollInterval:   time.Minute,
+	}
+}
+
+// Run удаляет пользователей раз в pollInterval, пока не отменен ctx
+func (p *AccountPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		users, err := p.users.FindDueForPurge(time.Now(), p.batchSize)
+		if err != nil {
+			log.Println("Не удалось получить пользователей на удаление", err)
+		}
+		for _, user := range users {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := p.purge(ctx, user); err != nil {
+				log.Println("Не удалось удалить пользователя", user.ID, err)
+				continue
+			}
+			log.Println("Пользователь окончательно удален", user.ID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *AccountPurger) purge(ctx context.Context, user domain.User) error {
+	// anketa_id известен только auth-service, поэтому его аккаунт удаляется последним
+	anketaId, err := setAccountDisabled(p.authClient, user.ID, true)
+	if err != nil {
+		return err
+	}
+
+	err = p.post(ctx, p.anketasClient, "/internal/purge", map[string]string{
+		"user_id":   user.ID.String(),
+		"anketa_id": anketaId,
+	})
+	if err != nil {
+		return fmt.Errorf("anketas-service: %w", err)
+	}
+
+	if anketaId != "" {
+		err = p.post(ctx, p.messagesClient, "/internal/purge", map[string]string{"anketa_id": anketaId})
+		if err != nil {
+			return fmt.Errorf("messages-service: %w", err)
+		}
+	}
+
+	err = p.post(ctx, p.authClient, "/deleteAccount", map[string]string{"user_id": user.ID.String()})
+	if err != nil {
+		return fmt.Errorf("auth-service: %w", err)
+	}
+
+	// в outbox лежат хеш пароля, email и телефон из регистрации
+	if err := p.outbox.DeleteByUser(user.ID); err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	if err := p.codes.DeleteByUser(user.ID); err != nil {
+		return fmt.Errorf("коды подтверждения: %w", err)
+	}
+
+	if err := p.deleteExports(user.ID); err != nil {
+		return fmt.Errorf("выгрузки: %w", err)
+	}
+
+	return p.users.Delete(user.ID)
+}
+
+// deleteExports стирает архивы выгрузок и сами задания; архив удаляется первым,
+// чтобы при ошибке задание осталось и архив нашелся в следующем проходе
+func (p *AccountPurger) deleteExports(userID uuid.UUID) error {
+	jobs, err := p.exports.FindByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.FileID != "" {
+			if err := p.exportStorage.Delete(job.FileID); err != nil {
+				return err
+			}
+		}
+		if err := p.exports.Delete(job.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *AccountPurger) post(ctx context.Context, client *authkit.InternalClient, path string, payload any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := client.PostJSON(ctx, path, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("статус %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"io"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type ExportServiceImpl struct {
+	users   domain.UserRepo
+	exports domain.ExportRepo
+	storage domain.ExportStorage
+}
+
+func NewExportService(users domain.UserRepo, exports domain.ExportRepo, storage domain.ExportStorage) domain.ExportService {
+	return ExportServiceImpl{users, exports, storage}
+}
+
+// RequestExport ставит выгрузку в очередь. Пока предыдущая собирается или ее архив
+// не истек, возвращается она же, чтобы повторные запросы не плодили архивы
+func (s ExportServiceImpl) RequestExport(userID uuid.UUID) (domain.ExportJob, error) {
+	_, err := s.users.FindByID(userID)
+	if err == mongo.ErrNoDocuments {
+		return domain.ExportJob{}, errs.ErrUserNotFound
+	}
+	if err != nil {
+		return domain.ExportJob{}, err
+	}
+
+	latest, ok, err := s.exports.FindLatest(userID)
+	if err != nil {
+		return domain.ExportJob{}, err
+	}
+	if ok && latest.IsActive(time.Now()) {
+		return latest, nil
+	}
+
+	job := domain.NewExportJob(userID)
+	if err := s.exports.Create(job); err != nil {
+		return domain.ExportJob{}, err
+	}
+	return job, nil
+}
+
+func (s ExportServiceImpl) GetExport(userID, jobID uuid.UUID) (domain.ExportJob, error) {
+	job, err := s.exports.FindByID(jobID)
+	if err == mongo.ErrNoDocuments || (err == nil && job.UserID != userID) {
+		return domain.ExportJob{}, errs.ErrExportNotFound
+	}
+	return job, err
+}
+
+// OpenExport открывает готовый архив; закрывает его вызывающий
+func (s ExportServiceImpl) OpenExport(userID, jobID uuid.UUID) (io.ReadCloser, domain.ExportJob, error) {
+	job, err := s.GetExport(userID, jobID)
+	if err != nil {
+		return nil, domain.ExportJob{}, err
+	}
+
+	switch {
+	case job.Status == domain.ExportPending || job.Status == domain.ExportRunning:
+		return nil, domain.ExportJob{}, errs.ErrExportNotReady
+	case job.Status != domain.ExportReady || !job.IsActive(time.Now()):
+		return nil, domain.ExportJob{}, errs.ErrExportNotFound
+	}
+
+	file, err := s.storage.Open(job.FileID)
+	if err != nil {
+		return nil, domain.ExportJob{}, err
+	}
+	return file, job, nil
+}
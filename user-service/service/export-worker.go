@@ -0,0 +1,308 @@
+package service
+
+import (
+	"archive/zip"
+	"auth-kit"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+)
+
+// ExportWorker собирает архивы выгрузок: данные пользователя, учетные данные и сессии
+// из auth-service, анкету с лайками и фотографиями из anketas-service и переписку из
+// messages-service. Готовые архивы хранятся ttl, затем удаляются
+type ExportWorker struct {
+	exports        domain.ExportRepo
+	storage        domain.ExportStorage
+	users          domain.UserRepo
+	authClient     *authkit.InternalClient
+	anketasClient  *authkit.InternalClient
+	messagesClient *authkit.InternalClient
+	photoClient    *http.Client
+	ttl            time.Duration
+	maxAttempts    int
+	retryDelay     time.Duration
+	pollInterval   time.Duration
+	lease          time.Duration
+}
+
+func NewExportWorker(exports domain.ExportRepo, storage domain.ExportStorage, users domain.UserRepo,
+	authClient, anketasClient, messagesClient *authkit.InternalClient, ttl time.Duration) *ExportWorker {
+	return &ExportWorker{
+		exports:        exports,
+		storage:        storage,
+		users:          users,
+		authClient:     authClient,
+		anketasClient:  anketasClient,
+		messagesClient: messagesClient,
+		photoClient:    &http.Client{Timeout: time.Minute},
+		ttl:            ttl,
+		maxAttempts:    3,
+		retryDelay:     time.Minute,
+		pollInterval:   5 * time.Second,
+		lease:          15 * time.Minute,
+	}
+}
+
+// Run собирает выгрузки из очереди и раз в час удаляет истекшие, пока не отменен ctx
+func (w *ExportWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		for ctx.Err() == nil {
+			job, ok, err := w.exports.Claim(time.Now(), w.lease)
+			if err != nil {
+				log.Println("Не удалось получить выгрузку из очереди", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			w.process(ctx, job)
+		}
+
+		if time.Since(lastCleanup) > time.Hour {
+			w.cleanup()
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *ExportWorker) process(ctx context.Context, job domain.ExportJob) {
+	fileID, size, err := w.build(ctx, job)
+	if err == nil {
+		err := w.exports.MarkReady(job.ID, fileID, size, time.Now().Add(w.ttl))
+		if err == errs.ErrExportNotFound {
+			// аккаунт удален, пока собирался архив: архив никому не принадлежит
+			if err := w.storage.Delete(fileID); err != nil {
+				log.Println("Не удалось удалить архив удаленной выгрузки", job.ID, err)
+			}
+			return
+		}
+		if err != nil {
+			log.Println("Не удалось отметить готовность выгрузки", job.ID, err)
+		}
+		return
+	}
+
+	if job.Attempts >= w.maxAttempts {
+		log.Println("Выгрузка не удалась", job.ID, err)
+		if err := w.exports.MarkFailed(job.ID, err.Error()); err != nil {
+			log.Println("Не удалось отметить неудачную выгрузку", job.ID, err)
+		}
+		return
+	}
+
+	log.Println("Сборка выгрузки не удалась, повтор позже", job.ID, err)
+	if err := w.exports.Reschedule(job.ID, time.Now().Add(w.retryDelay), err.Error()); err != nil {
+		log.Println("Не удалось отложить выгрузку", job.ID, err)
+	}
+}
+
+// build пишет архив во временный файл и сохраняет его в хранилище
+func (w *ExportWorker) build(ctx context.Context, job domain.ExportJob) (string, int64, error) {
+	user, err := w.users.FindByID(job.UserID)
+	if err != nil {
+		return "", 0, fmt.Errorf("пользователь: %w", err)
+	}
+
+	auth, err := w.fetch(ctx, w.authClient, "/exportAccount", map[string]string{"user_id": user.ID.String()})
+	if err != nil {
+		return "", 0, fmt.Errorf("auth-service: %w", err)
+	}
+
+	var account struct {
+		Account struct {
+			AnketaId string `json:"anketa_id"`
+		} `json:"account"`
+	}
+	if auth != nil {
+		_ = json.Unmarshal(auth, &account)
+	}
+	anketaId := account.Account.AnketaId
+
+	anketa, err := w.fetch(ctx, w.anketasClient, "/internal/export", map[string]string{
+		"user_id":   user.ID.String(),
+		"anketa_id": anketaId,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("anketas-service: %w", err)
+	}
+
+	var messages json.RawMessage
+	if anketaId != "" {
+		messages, err = w.fetch(ctx, w.messagesClient, "/internal/export", map[string]string{"anketa_id": anketaId})
+		if err != nil {
+			return "", 0, fmt.Errorf("messages-service: %w", err)
+		}
+	}
+
+	file, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	// пароль хранится только хешем и в выгрузку не попадает
+	profile := map[string]any{
+		"id":             user.ID.String(),
+		"login":          user.Login.String(),
+		"email":          user.Email.String(),
+		"phone_number":   user.PhoneNumber.String(),
+		"email_verified": user.EmailVerified,
+		"phone_verified": user.PhoneVerified,
+		"status":         user.Status,
+	}
+	if !user.PurgeAt.IsZero() {
+		profile["purge_at"] = user.PurgeAt
+	}
+
+	documents := []struct {
+		name string
+		data any
+	}{
+		{"user.json", profile},
+		{"auth.json", auth},
+		{"anketa.json", anketa},
+		{"messages.json", messages},
+	}
+	for _, document := range documents {
+		if err := writeJSON(archive, document.name, document.data); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := w.addPhotos(ctx, archive, anketa); err != nil {
+		return "", 0, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", 0, err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	fileID, err := w.storage.Save("export-"+job.ID.String()+".zip", file)
+	if err != nil {
+		return "", 0, err
+	}
+	return fileID, size, nil
+}
+
+// addPhotos скачивает фотографии по ссылкам из ответа anketas-service
+func (w *ExportWorker) addPhotos(ctx context.Context, archive *zip.Writer, anketa json.RawMessage) error {
+	var export struct {
+		Photos []struct {
+			Key string `json:"key"`
+			URL string `json:"url"`
+		} `json:"photos"`
+	}
+	if anketa != nil {
+		if err := json.Unmarshal(anketa, &export); err != nil {
+			return err
+		}
+	}
+
+	for _, photo := range export.Photos {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, photo.URL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := w.photoClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("фотография %s: %w", photo.Key, err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("фотография %s: статус %d", photo.Key, resp.StatusCode)
+		}
+
+		entry, err := archive.Create("photos/" + path.Base(photo.Key))
+		if err == nil {
+			_, err = io.Copy(entry, resp.Body)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch возвращает тело ответа сервиса; 404 означает, что данных нет
+func (w *ExportWorker) fetch(ctx context.Context, client *authkit.InternalClient, path string, payload any) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	resp, err := client.PostJSON(ctx, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("статус %d", resp.StatusCode)
+	}
+}
+
+// cleanup удаляет архивы, срок хранения которых истек
+func (w *ExportWorker) cleanup() {
+	jobs, err := w.exports.FindExpired(time.Now())
+	if err != nil {
+		log.Println("Не удалось получить истекшие выгрузки", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if err := w.storage.Delete(job.FileID); err != nil {
+			log.Println("Не удалось удалить архив выгрузки", job.ID, err)
+			continue
+		}
+		if err := w.exports.Delete(job.ID); err != nil {
+			log.Println("Не удалось удалить выгрузку", job.ID, err)
+		}
+	}
+}
+
+func writeJSON(archive *zip.Writer, name string, data any) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
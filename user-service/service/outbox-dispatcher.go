@@ -0,0 +1,197 @@
+package service
+
+import (
+	"auth-kit"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"user-service/domain"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// OutboxDispatcher доставляет сообщения outbox в auth-service. Неудачные попытки
+// повторяются с экспоненциальной задержкой, после maxAttempts сообщение уходит в dead,
+// а пользователь помечается failed и удаляется AccountPurger
+type OutboxDispatcher struct {
+	outbox       domain.OutboxRepo
+	users        domain.UserRepo
+	authClient   *authkit.InternalClient
+	maxAttempts  int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	pollInterval time.Duration
+	lease        time.Duration
+}
+
+func NewOutboxDispatcher(outbox domain.OutboxRepo, users domain.UserRepo, authClient *authkit.InternalClient) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		outbox:       outbox,
+		users:        users,
+		authClient:   authClient,
+		maxAttempts:  10,
+		baseDelay:    2 * time.Second,
+		maxDelay:     5 * time.Minute,
+		pollInterval: time.Second,
+		lease:        time.Minute,
+	}
+}
+
+// errPermanent - ответ, который не изменится при повторе
+type errPermanent struct {
+	status int
+	body   string
+}
+
+func (e errPermanent) Error() string {
+	return fmt.Sprintf("auth-service вернул статус %d: %s", e.status, e.body)
+}
+
+// Run разбирает outbox, пока не отменен ctx
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// выбираем все готовые сообщения, потом ждем следующего тика
+		for ctx.Err() == nil {
+			message, ok, err := d.outbox.Claim(time.Now(), d.lease)
+			if err != nil {
+				log.Println("Не удалось получить сообщение outbox", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			d.process(ctx, message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *OutboxDispatcher) process(ctx context.Context, message domain.OutboxMessage) {
+	err := d.deliver(ctx, message)
+	if err == nil {
+		// сообщение закрывается только после статуса пользователя; повторная доставка
+		// идет с тем же Idempotency-Key, поэтому auth-service ее не применит дважды
+		if err := d.onDelivered(ctx, message); err != nil {
+			d.reschedule(message, err)
+			return
+		}
+		if err := d.outbox.MarkDelivered(message.ID); err != nil {
+			log.Println("Не удалось отметить доставку сообщения outbox", message.ID, err)
+		}
+		return
+	}
+
+	var permanent errPermanent
+	if errors.As(err, &permanent) || message.Attempts >= d.maxAttempts {
+		log.Println("Сообщение outbox отправлено в dead", message.ID, err)
+		if err := d.outbox.DeadLetter(message.ID, err.Error()); err != nil {
+			log.Println("Не удалось отправить сообщение outbox в dead", message.ID, err)
+		}
+		d.onDead(message)
+		return
+	}
+
+	d.reschedule(message, err)
+}
+
+func (d *OutboxDispatcher) reschedule(message domain.OutboxMessage, cause error) {
+	next := time.Now().Add(d.backoff(message.Attempts))
+	log.Println("Обработка сообщения outbox не удалась, повтор в", next.Format(time.RFC3339), message.ID, cause)
+	if err := d.outbox.Reschedule(message.ID, next, cause.Error()); err != nil {
+		log.Println("Не удалось отложить сообщение outbox", message.ID, err)
+	}
+}
+
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseDelay
+	for i := 1; i < attempts && delay < d.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxDelay)
+}
+
+func (d *OutboxDispatcher) deliver(ctx context.Context, message domain.OutboxMessage) error {
+	var path string
+	switch message.Type {
+	case domain.OutboxUserRegistered:
+		path = "/userReg"
+	default:
+		return errPermanent{body: "неизвестный тип сообщения " + message.Type}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := d.authClient.PostJSONIdempotent(ctx, path, message.IdempotencyKey, message.Payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// 4xx кроме 429 - ошибка в самом сообщении, повтор не поможет
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return errPermanent{status: resp.StatusCode, body: string(body)}
+	}
+	return fmt.Errorf("auth-service вернул статус %d: %s", resp.StatusCode, body)
+}
+
+// onDelivered открывает вход в auth-service и затем активирует пользователя. Пользователь,
+// который уже не pending (повторная доставка), не считается ошибкой
+func (d *OutboxDispatcher) onDelivered(ctx context.Context, message domain.OutboxMessage) error {
+	if message.Type != domain.OutboxUserRegistered {
+		return nil
+	}
+	if err := d.activateAccount(ctx, message.UserID); err != nil {
+		return fmt.Errorf("не удалось открыть вход в auth-service: %w", err)
+	}
+	err := d.users.SetStatus(message.UserID, domain.UserStatusPending, domain.UserStatusActive)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("не удалось активировать пользователя: %w", err)
+	}
+	return nil
+}
+
+func (d *OutboxDispatcher) activateAccount(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := d.authClient.PostJSON(ctx, "/activateAccount", map[string]string{"user_id": userID.String()})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth-service вернул статус %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// onDead помечает регистрацию неудавшейся; AccountPurger удалит ее и освободит учетные данные
+func (d *OutboxDispatcher) onDead(message domain.OutboxMessage) {
+	if message.Type != domain.OutboxUserRegistered {
+		return
+	}
+	err := d.users.FailRegistration(message.UserID, time.Now())
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println("Не удалось отметить неудачную регистрацию", message.UserID, err)
+	}
+}
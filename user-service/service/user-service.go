@@ -1,9 +1,13 @@
 package service
 
 import (
-	"bytes"
+	"auth-kit"
+	"context"
 	"encoding/json"
+	"io"
+	"log"
 	"net/http"
+	"time"
 	"user-service/domain"
 	errs "user-service/errors"
 	"user-service/valueObjects"
@@ -12,11 +16,19 @@ import (
 )
 
 type UserServiceImpl struct {
-	repo domain.UserRepo
+	repo          domain.UserRepo
+	history       domain.HistoryRepo
+	codes         domain.VerificationRepo
+	emailSender   domain.Sender
+	smsSender     domain.Sender
+	authClient    *authkit.InternalClient
+	anketasClient *authkit.InternalClient
+	deletionGrace time.Duration
 }
 
-func NewUserService(repo domain.UserRepo) domain.UserService {
-	return UserServiceImpl{repo}
+func NewUserService(repo domain.UserRepo, history domain.HistoryRepo, codes domain.VerificationRepo, emailSender, smsSender domain.Sender,
+	authClient, anketasClient *authkit.InternalClient, deletionGrace time.Duration) domain.UserService {
+	return UserServiceImpl{repo, history, codes, emailSender, smsSender, authClient, anketasClient, deletionGrace}
 }
 
 func (s UserServiceImpl) Register(login, email, phone, password string) (uuid.UUID, error) {
@@ -40,82 +52,270 @@ func (s UserServiceImpl) Register(login, email, phone, password string) (uuid.UU
 		return uuid.Nil, err
 	}
 
-	if exists, _ := s.repo.ExistsByLogin(loginVO.String()); exists {
+	// быстрая проверка ради понятной ошибки; гонку двух регистраций закрывают уникальные индексы
+	if exists, err := s.repo.ExistsByLogin(loginVO.String()); err != nil {
+		return uuid.Nil, err
+	} else if exists {
 		return uuid.Nil, errs.ErrLoginAlreadyExists
 	}
 
-	if exists, _ := s.repo.ExistsByEmail(emailVO.String()); exists {
+	if exists, err := s.repo.ExistsByEmail(emailVO.String()); err != nil {
+		return uuid.Nil, err
+	} else if exists {
 		return uuid.Nil, errs.ErrEmailAlreadyExists
 	}
 
-	if exists, _ := s.repo.ExistsByPhone(phoneVO.String()); exists {
+	if exists, err := s.repo.ExistsByPhone(phoneVO.String()); err != nil {
+		return uuid.Nil, err
+	} else if exists {
 		return uuid.Nil, errs.ErrPhoneAlreadyExists
 	}
 
 	user := domain.NewUser(loginVO, passwordVO, phoneVO, emailVO)
-	var userStrings struct {
-		Login    string
-		Email    string
-		Phone    string
-		Password string
-		UserId   string `json:"user_id"`
-	}
-	userStrings.Email = user.Email.String()
-	userStrings.Phone = user.PhoneNumber.String()
-	userStrings.Login = user.Login.String()
-	userStrings.Password = user.PasswordHash.String()
-	userStrings.UserId = user.ID.String()
-
-	dataToSend, err := json.Marshal(userStrings)
+
+	// аккаунт в auth-service создаст диспетчер outbox; до подтверждения пользователь в статусе pending
+	message := domain.NewOutboxMessage(domain.OutboxUserRegistered, user.ID, map[string]any{
+		"Login":    user.Login.String(),
+		"Email":    user.Email.String(),
+		"Phone":    user.PhoneNumber.String(),
+		"Password": user.PasswordHash.String(),
+		"user_id":  user.ID.String(),
+	})
+
+	err = s.repo.CreateWithOutbox(user, message)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	http.Post("http://localhost:8001/userReg", "application/json", bytes.NewBuffer(dataToSend))
+	return user.ID, nil
+}
+
+// Login проверяет учетные данные в auth-service и возвращает его ответ: пару токенов,
+// требование второго фактора или ошибку входа. Пароль, блокировки и сессии ведет auth-service
+func (s UserServiceImpl) Login(credType, identifier, password string, client domain.ClientInfo) (domain.AuthResponse, error) {
+	user, err := s.repo.FindByCredential(credType, identifier)
+	if err == errs.ErrInvalidCredType {
+		return domain.AuthResponse{}, err
+	}
+	// незавершенная регистрация не пускается, даже если аккаунт в auth-service уже есть.
+	// Деактивированный аккаунт отклонит сам auth-service после проверки пароля
+	if err == nil && !user.IsActive() && !user.IsRestorable() {
+		return domain.AuthResponse{}, errs.ErrUserNotActive
+	}
+
+	return s.authLogin(credType, identifier, password, client)
+}
+
+func (s UserServiceImpl) authLogin(credType, identifier, password string, client domain.ClientInfo) (domain.AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	err = s.repo.Create(user)
+	// адрес и User-Agent клиента идут в подписанном теле, иначе все входы считались бы с адреса user-service
+	resp, err := s.authClient.PostJSON(ctx, "/internalLogin", map[string]string{
+		"creds":      credType,
+		"value":      identifier,
+		"password":   password,
+		"client_ip":  client.IP,
+		"user_agent": client.UserAgent,
+	})
 	if err != nil {
-		return uuid.Nil, err
+		log.Println("Не удалось выполнить вход через auth-service", err)
+		return domain.AuthResponse{}, errs.ErrAuthUnavailable
 	}
+	defer resp.Body.Close()
 
-	return user.ID, nil
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return domain.AuthResponse{}, errs.ErrAuthUnavailable
+	}
+
+	return domain.AuthResponse{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+		RetryAfter: resp.Header.Get("Retry-After"),
+	}, nil
 }
 
-func (s UserServiceImpl) Login(login, password string) (string, error) {
-	user, err := s.repo.FindByLogin(login)
+// Update сначала меняет учетные данные в auth-service, затем в базе вместе с записью в историю от имени actor.
+// Если база не обновилась, auth-service возвращается к данным из базы
+func (s UserServiceImpl) Update(id uuid.UUID, expectedVersion int64, actor string, opts ...domain.UpdateOption) error {
+
+	update := domain.NewUserUpdate()
+
+	for _, opt := range opts {
+		opt(update)
+	}
+
+	old, err := s.repo.FindByID(id)
 	if err != nil {
-		return "", errs.ErrLoginNotExists
+		return err
+	}
+	// устаревшая версия отсекается до похода в auth-service; гонку после проверки ловит сам repo.Update
+	if old.Version != expectedVersion {
+		return errs.ErrVersionConflict
 	}
 
-	if !user.CheckPassword(password) {
-		return "", errs.ErrPasswordNotExists
+	changed := credentialsPayload(id, update.FieldsToUpdate)
+	previousHash := ""
+	if len(changed) > 1 {
+		previousHash, err = s.updateAuthCredentials(changed)
+		if err != nil {
+			return err
+		}
 	}
 
-	token, err := s.generateToken(user.ID)
+	changes := domain.NewChangeRecords(old, *update, actor, expectedVersion+1)
+	err = s.repo.Update(id, *update, expectedVersion, changes)
 	if err != nil {
-		return "", errs.ErrTokenGenerationFailed
+		if len(changed) > 1 {
+			s.rollbackAuthCredentials(id, changed, previousHash)
+		}
+		return err
 	}
 
-	return token, nil
+	// код, отправленный на прежний адрес или телефон, больше не действует
+	s.dropPendingCodes(id, update.FieldsToUpdate)
+	return nil
 }
 
-func (s UserServiceImpl) Delete(id uuid.UUID) error {
-	return s.repo.Delete(id)
+func (s UserServiceImpl) dropPendingCodes(id uuid.UUID, fields map[string]string) {
+	channels := map[string]domain.VerificationChannel{
+		domain.FieldEmail: domain.ChannelEmail,
+		domain.FieldPhone: domain.ChannelPhone,
+	}
+	for field, channel := range channels {
+		if _, ok := fields[field]; !ok {
+			continue
+		}
+		if err := s.codes.Delete(id, channel); err != nil {
+			log.Println("Не удалось удалить код подтверждения", id, channel, err)
+		}
+	}
 }
 
-func (s UserServiceImpl) Update(id uuid.UUID, opts ...domain.UpdateOption) error {
+// rollbackAuthCredentials возвращает auth-service к данным из базы и прежнему хешу пароля, только
+// пока он хранит записанные этим обновлением значения written. Если их уже сменил другой запрос,
+// логин, email и телефон синхронизируются с тем, что сейчас лежит в базе, а пароль не трогается
+func (s UserServiceImpl) rollbackAuthCredentials(id uuid.UUID, written map[string]any, previousHash string) {
+	expected := map[string]any{}
+	for field, value := range written {
+		if field != "user_id" {
+			expected[field] = value
+		}
+	}
 
-	update := domain.NewUserUpdate()
+	payload, err := s.storedCredentials(id)
+	if err != nil {
+		log.Println("Не удалось прочитать пользователя для отката учетных данных", id, err)
+		return
+	}
+	if previousHash != "" {
+		payload["password_hash"] = previousHash
+	}
+	payload["expected"] = expected
 
-	for _, opt := range opts {
-		opt(update)
+	_, err = s.updateAuthCredentials(payload)
+	if err == errs.ErrCredentialsMismatch {
+		payload, err = s.storedCredentials(id)
+		if err == nil {
+			_, err = s.updateAuthCredentials(payload)
+		}
+	}
+	if err != nil {
+		log.Println("Не удалось откатить учетные данные в auth-service для", id, err)
+	}
+}
+
+// storedCredentials - учетные данные пользователя в базе в формате /updateCredentials
+func (s UserServiceImpl) storedCredentials(id uuid.UUID) (map[string]any, error) {
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"user_id":        id.String(),
+		"login":          user.Login.String(),
+		"email":          user.Email.String(),
+		"phone":          user.PhoneNumber.String(),
+		"email_verified": user.EmailVerified,
+		"phone_verified": user.PhoneVerified,
+	}, nil
+}
+
+func (s UserServiceImpl) GetHistory(id uuid.UUID, before string, limit int) ([]domain.ChangeRecord, error) {
+	return s.history.FindByUser(id, before, limit)
+}
+
+// credentialsPayload собирает из обновления поля, которые хранит auth-service
+func credentialsPayload(id uuid.UUID, fields map[string]string) map[string]any {
+	payload := map[string]any{"user_id": id.String()}
+	authFields := map[string]string{
+		domain.FieldLogin:    "login",
+		domain.FieldEmail:    "email",
+		domain.FieldPhone:    "phone",
+		domain.FieldPassword: "password_hash",
 	}
+	for field, authField := range authFields {
+		if value, ok := fields[field]; ok {
+			payload[authField] = value
+		}
+	}
+	return payload
+}
+
+// updateAuthCredentials меняет учетные данные в auth-service и при смене пароля возвращает прежний хеш
+func (s UserServiceImpl) updateAuthCredentials(payload map[string]any) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := s.authClient.PostJSON(ctx, "/updateCredentials", payload)
+	if err != nil {
+		log.Println("Не удалось обновить учетные данные в auth-service", err)
+		return "", errs.ErrCredentialsSyncFailed
+	}
+	defer resp.Body.Close()
 
-	return s.repo.Update(id, *update)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var result struct {
+			PreviousPasswordHash string `json:"previous_password_hash"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			log.Println("Не удалось разобрать ответ auth-service", err)
+		}
+		return result.PreviousPasswordHash, nil
+	case http.StatusConflict:
+		return "", errs.ErrCredentialsTaken
+	case http.StatusPreconditionFailed:
+		return "", errs.ErrCredentialsMismatch
+	default:
+		log.Println("auth-service отклонил обновление учетных данных, статус", resp.StatusCode)
+		return "", errs.ErrCredentialsSyncFailed
+	}
 }
 
 func (s UserServiceImpl) GetUserByID(id uuid.UUID) (domain.User, error) {
-	return s.repo.FindByID(id)
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	// скрытый пользователь выглядит для остальных как удаленный
+	if user.Status == domain.UserStatusDeactivated || user.Status == domain.UserStatusDeletionSchedule {
+		return domain.User{}, errs.ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ListUsers - список для администратора, включая скрытых и ожидающих удаления пользователей
+func (s UserServiceImpl) ListUsers(query domain.UserListQuery) (domain.UserPage, error) {
+	switch query.SortBy {
+	case "":
+		query.SortBy, query.Desc = domain.SortByCreatedAt, true
+	case domain.SortByCreatedAt, domain.SortByLogin, domain.SortByEmail:
+	default:
+		return domain.UserPage{}, errs.ErrInvalidListSort
+	}
+	return s.repo.List(query)
 }
 
 func (s UserServiceImpl) CheckLoginExists(login string) (bool, error) {
@@ -129,7 +329,3 @@ func (s UserServiceImpl) CheckEmailExists(email string) (bool, error) {
 func (s UserServiceImpl) CheckPhoneExists(phone string) (bool, error) {
 	return s.repo.ExistsByPhone(phone)
 }
-
-func (s UserServiceImpl) generateToken(id uuid.UUID) (string, error) {
-	return "generated-token-" + id.String(), nil
-}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"auth-kit"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+	"user-service/domain"
+	errs "user-service/errors"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	verificationCodeTTL         = 15 * time.Minute
+	verificationResendCooldown  = time.Minute
+	verificationCodeMaxAttempts = 5
+)
+
+func (s UserServiceImpl) SendVerificationCode(id uuid.UUID, channel domain.VerificationChannel) error {
+	if !channel.Valid() {
+		return errs.ErrInvalidVerificationChannel
+	}
+
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+
+	destination, sender := verificationDestination(user, channel), s.emailSender
+	verified := user.EmailVerified
+	if channel == domain.ChannelPhone {
+		sender = s.smsSender
+		verified = user.PhoneVerified
+	}
+	if verified {
+		return errs.ErrAlreadyVerified
+	}
+
+	previous, err := s.codes.Find(id, channel)
+	if err == nil && time.Since(previous.CreatedAt) < verificationResendCooldown {
+		return errs.ErrVerificationTooFrequent
+	}
+
+	code, err := authkit.NewNumericCode(6)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = s.codes.Save(domain.VerificationCode{
+		UserID:      id,
+		Channel:     channel,
+		Destination: destination,
+		CodeHash:    authkit.HashCode(code),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(verificationCodeTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	message := fmt.Sprintf("Код подтверждения: %s. Он действует %d мин.", code, int(verificationCodeTTL.Minutes()))
+	return sender.Send(ctx, destination, message)
+}
+
+func (s UserServiceImpl) ConfirmVerification(id uuid.UUID, channel domain.VerificationChannel, code string) error {
+	if !channel.Valid() {
+		return errs.ErrInvalidVerificationChannel
+	}
+
+	stored, err := s.codes.Find(id, channel)
+	if err != nil || time.Now().After(stored.ExpiresAt) {
+		return errs.ErrVerificationCodeInvalid
+	}
+
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	// код подтверждает только тот адрес или телефон, на который был отправлен
+	if stored.Destination != verificationDestination(user, channel) {
+		_ = s.codes.Delete(id, channel)
+		return errs.ErrVerificationCodeInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(authkit.HashCode(code))) != 1 {
+		attempts, err := s.codes.IncrementAttempts(id, channel)
+		if err == nil && attempts >= verificationCodeMaxAttempts {
+			_ = s.codes.Delete(id, channel)
+		}
+		return errs.ErrVerificationCodeInvalid
+	}
+
+	err = s.repo.SetVerified(id, channel, stored.Destination, true)
+	if err == mongo.ErrNoDocuments {
+		_ = s.codes.Delete(id, channel)
+		return errs.ErrVerificationCodeInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	// код удаляется только после того, как auth-service узнал о подтверждении,
+	// иначе повторная попытка была бы невозможна
+	err = s.notifyCredentialVerified(id, channel, true)
+	if err != nil {
+		return err
+	}
+
+	return s.codes.Delete(id, channel)
+}
+
+func verificationDestination(user domain.User, channel domain.VerificationChannel) string {
+	if channel == domain.ChannelPhone {
+		return user.PhoneNumber.String()
+	}
+	return user.Email.String()
+}
+
+// notifyCredentialVerified передает статус подтверждения в auth-service, который проверяет его при входе
+func (s UserServiceImpl) notifyCredentialVerified(id uuid.UUID, channel domain.VerificationChannel, verified bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := s.authClient.PostJSON(ctx, "/setCredentialVerified", map[string]any{
+		"user_id":   id.String(),
+		"cred_type": string(channel),
+		"verified":  verified,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth-service вернул статус %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package transport
 
 import (
+	"auth-kit"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"user-service/domain"
 	errs "user-service/errors"
 	"user-service/valueObjects"
@@ -13,10 +17,12 @@ import (
 
 type UserHandler struct {
 	userService domain.UserService
+	exports     domain.ExportService
+	auth        *authkit.Authenticator
 }
 
-func NewUserHandler(service domain.UserService) UserHandler {
-	return UserHandler{service}
+func NewUserHandler(service domain.UserService, exports domain.ExportService, auth *authkit.Authenticator) UserHandler {
+	return UserHandler{service, exports, auth}
 }
 
 func (h *UserHandler) Register(c *gin.Context) {
@@ -49,12 +55,15 @@ func (h *UserHandler) Register(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{
 		"status": "Пользователь успешно создан",
 		"user_id": userID.String(),
+		"registration_status": domain.UserStatusPending,
 	})
 }
 
 func (h *UserHandler) Login(c *gin.Context) {
 	var request struct {
-		Login    string `json:"login" binding:"required"`
+		Creds    string `json:"creds"`
+		Value    string `json:"value"`
+		Login    string `json:"login"`
 		Password string `json:"password" binding:"required"`
 	}
 
@@ -63,20 +72,35 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := h.userService.Login(request.Login, request.Password)
+	// старые клиенты присылают только login
+	if request.Creds == "" && request.Login != "" {
+		request.Creds, request.Value = "login", request.Login
+	}
+	if request.Creds == "" || request.Value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите creds и value или login"})
+		return
+	}
+
+	client := domain.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	resp, err := h.userService.Login(request.Creds, request.Value, request.Password, client)
 	if err != nil {
 		switch err {
-		case errs.ErrLoginNotExists:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		case errs.ErrPasswordNotExists:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errs.ErrInvalidCredType:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errs.ErrUserNotActive:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errs.ErrAuthUnavailable:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
 		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	if resp.RetryAfter != "" {
+		c.Header("Retry-After", resp.RetryAfter)
+	}
+	c.Data(resp.StatusCode, "application/json; charset=utf-8", resp.Body)
 }
 
 func (h *UserHandler) UpdateUser(c *gin.Context) {
@@ -87,6 +111,12 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// изменение применяется только к версии, которую видел клиент
+	expectedVersion, ok := authkit.RequireVersion(c, "пользователя")
+	if !ok {
+		return
+	}
+
 	var request struct {
 		Login    *string `json:"login,omitempty"`
 		Email    *string `json:"email,omitempty"`
@@ -141,12 +171,22 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		opts = append(opts, domain.WithPassword(passwordVO))
 	}
 
-	err = h.userService.Update(id, opts...)
+	claims, _ := authkit.ClaimsFromContext(c)
+	err = h.userService.Update(id, expectedVersion, claims.UserID(), opts...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch err {
+		case errs.ErrVersionConflict:
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		case errs.ErrCredentialsTaken, errs.ErrLoginAlreadyExists,
+			errs.ErrEmailAlreadyExists, errs.ErrPhoneAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
+	c.Header("ETag", authkit.FormatETag(expectedVersion+1))
 	c.JSON(http.StatusOK, gin.H{"status": "Пользователь успешно обновлен!"})
 }
 
@@ -158,13 +198,95 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	err = h.userService.Delete(id)
+	purgeAt, err := h.userService.Delete(id)
+	if err != nil {
+		respondLifecycleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Удаление аккаунта запланировано, до этого момента его можно восстановить",
+		"purge_at": purgeAt,
+	})
+}
+
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return
+	}
+
+	err = h.userService.Deactivate(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondLifecycleError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "Пользователь успешно удален!"})
+	c.JSON(http.StatusOK, gin.H{"status": "Аккаунт деактивирован"})
+}
+
+// RestoreUser восстанавливает деактивированный аккаунт по данным для входа и возвращает ответ входа
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	var request struct {
+		Creds    string `json:"creds" binding:"required"`
+		Value    string `json:"value" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := domain.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	resp, err := h.userService.Restore(request.Creds, request.Value, request.Password, client)
+	if err != nil {
+		switch err {
+		case errs.ErrInvalidCredType:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errs.ErrAuthUnavailable:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		default:
+			respondLifecycleError(c, err)
+		}
+		return
+	}
+
+	if resp.RetryAfter != "" {
+		c.Header("Retry-After", resp.RetryAfter)
+	}
+	c.Data(resp.StatusCode, "application/json; charset=utf-8", resp.Body)
+}
+
+func (h *UserHandler) AdminRestoreUser(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return
+	}
+
+	err = h.userService.RestoreByID(id)
+	if err != nil {
+		respondLifecycleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Аккаунт восстановлен"})
+}
+
+func respondLifecycleError(c *gin.Context, err error) {
+	switch err {
+	case errs.ErrUserNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errs.ErrAlreadyDeactivated, errs.ErrNotRestorable, errs.ErrUserNotActive:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errs.ErrAccountSyncFailed:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		log.Println("Не удалось изменить статус аккаунта", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+	}
 }
 
 func (h *UserHandler) GetUser(c *gin.Context) {
@@ -186,16 +308,80 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 		Login string `json:"login"`
 		Email string `json:"email"`
 		PhoneNumber string `json:"phone_number"`
+		EmailVerified bool `json:"email_verified"`
+		PhoneVerified bool `json:"phone_verified"`
+		Status string `json:"status"`
 	}
 
+	c.Header("ETag", authkit.FormatETag(user.Version))
 	c.JSON(http.StatusOK, userDTO{
 		ID: user.ID.String(),
 		Login: user.Login.String(),
 		Email: user.Email.String(),
 		PhoneNumber: user.PhoneNumber.String(),
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+		Status: user.Status,
 	})
 }
 
+func (h *UserHandler) SendVerificationCode(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return
+	}
+
+	err = h.userService.SendVerificationCode(id, domain.VerificationChannel(c.Param("channel")))
+	if err != nil {
+		switch err {
+		case errs.ErrInvalidVerificationChannel:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errs.ErrAlreadyVerified:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errs.ErrVerificationTooFrequent:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			log.Println("Не удалось отправить код подтверждения", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Код подтверждения отправлен"})
+}
+
+func (h *UserHandler) ConfirmVerification(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.userService.ConfirmVerification(id, domain.VerificationChannel(c.Param("channel")), request.Code)
+	if err != nil {
+		switch err {
+		case errs.ErrInvalidVerificationChannel, errs.ErrVerificationCodeInvalid:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Println("Не удалось подтвердить учетные данные", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Успешно подтверждено"})
+}
+
 func (h *UserHandler) CheckLoginExists(c *gin.Context) {
 	login := c.Param("login")
 	exists, err := h.userService.CheckLoginExists(login)
@@ -238,12 +424,258 @@ func (h *UserHandler) CheckPhoneExists(c *gin.Context) {
 	}
 }
 
+// RequestExport ставит в очередь выгрузку персональных данных или возвращает текущую
+func (h *UserHandler) RequestExport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return
+	}
+
+	job, err := h.exports.RequestExport(id)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	status := http.StatusAccepted
+	if job.Status == domain.ExportReady {
+		status = http.StatusOK
+	}
+	c.JSON(status, exportJobResponse(c, job))
+}
+
+func (h *UserHandler) GetExport(c *gin.Context) {
+	id, jobID, ok := exportParams(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.exports.GetExport(id, jobID)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, exportJobResponse(c, job))
+}
+
+func (h *UserHandler) DownloadExport(c *gin.Context) {
+	id, jobID, ok := exportParams(c)
+	if !ok {
+		return
+	}
+
+	file, job, err := h.exports.OpenExport(id, jobID)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Disposition", `attachment; filename="export-`+job.ID.String()+`.zip"`)
+	c.DataFromReader(http.StatusOK, job.Size, "application/zip", file, nil)
+}
+
+func exportParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	jobID, err := uuid.Parse(c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди выгрузки"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return id, jobID, true
+}
+
+// exportJobResponse описывает выгрузку со ссылками на статус и архив; для запросов
+// через /admin ссылки тоже ведут в /admin
+func exportJobResponse(c *gin.Context, job domain.ExportJob) gin.H {
+	base := "/users/" + job.UserID.String() + "/export/" + job.ID.String()
+	if strings.HasPrefix(c.FullPath(), "/admin/") {
+		base = "/admin" + base
+	}
+
+	response := gin.H{
+		"job_id":     job.ID.String(),
+		"status":     job.Status,
+		"created_at": job.CreatedAt,
+		"status_url": base,
+	}
+	switch job.Status {
+	case domain.ExportReady:
+		response["download_url"] = base + "/download"
+		response["size"] = job.Size
+		response["expires_at"] = job.ExpiresAt
+	case domain.ExportFailed:
+		response["error"] = "Не удалось собрать выгрузку, запросите ее снова"
+	}
+	return response
+}
+
+func respondExportError(c *gin.Context, err error) {
+	switch err {
+	case errs.ErrUserNotFound, errs.ErrExportNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errs.ErrExportNotReady:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Println("Ошибка выгрузки персональных данных", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+	}
+}
+
+// listPageMax ограничивает размер страницы списка пользователей
+const listPageMax = 200
+
+// ListUsers - для администратора: поиск по префиксу логина, email или телефона,
+// фильтры по подтверждению и дате регистрации, сортировка sort=[-]created_at|login|email
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный limit"})
+		return
+	}
+
+	query := domain.UserListQuery{
+		Search: strings.TrimSpace(c.Query("q")),
+		Cursor: c.Query("cursor"),
+		Limit:  min(limit, listPageMax),
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		query.SortBy = strings.TrimPrefix(sort, "-")
+		query.Desc = strings.HasPrefix(sort, "-")
+	}
+
+	for param, target := range map[string]**bool{
+		"email_verified": &query.EmailVerified,
+		"phone_verified": &query.PhoneVerified,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный " + param})
+			return
+		}
+		*target = &verified
+	}
+
+	for param, target := range map[string]*time.Time{
+		"created_from": &query.CreatedFrom,
+		"created_to":   &query.CreatedTo,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный " + param + ", нужен формат RFC3339"})
+			return
+		}
+		*target = parsed
+	}
+
+	page, err := h.userService.ListUsers(query)
+	if err != nil {
+		switch err {
+		case errs.ErrInvalidListSort, errs.ErrInvalidListCursor:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Println("Ошибка получения списка пользователей", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		}
+		return
+	}
+
+	users := make([]gin.H, 0, len(page.Users))
+	for _, user := range page.Users {
+		users = append(users, gin.H{
+			"id":             user.ID.String(),
+			"login":          user.Login.String(),
+			"email":          user.Email.String(),
+			"phone_number":   user.PhoneNumber.String(),
+			"email_verified": user.EmailVerified,
+			"phone_verified": user.PhoneVerified,
+			"status":         user.Status,
+			"created_at":     user.CreatedAt,
+		})
+	}
+
+	response := gin.H{"users": users}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetHistory - для поддержки и модерации: кто и когда менял данные пользователя, от новых к старым
+func (h *UserHandler) GetHistory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный айди пользователя"})
+		return
+	}
+
+	limit, ok := authkit.HistoryLimit(c)
+	if !ok {
+		return
+	}
+
+	records, err := h.userService.GetHistory(id, c.Query("before"), limit)
+	if err != nil {
+		switch err {
+		case errs.ErrInvalidHistoryCursor:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Println("Ошибка чтения истории изменений", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		}
+		return
+	}
+
+	changes := make([]authkit.HistoryEntry, 0, len(records))
+	for _, record := range records {
+		changes = append(changes, authkit.HistoryEntry{
+			ID:        record.ID,
+			Field:     record.Field,
+			OldValue:  record.OldValue,
+			NewValue:  record.NewValue,
+			Actor:     record.Actor,
+			Version:   record.Version,
+			ChangedAt: record.ChangedAt,
+		})
+	}
+	authkit.RespondHistory(c, changes, limit)
+}
+
 func (h *UserHandler) RegisterRoutes(router *gin.Engine) {
 	router.POST("/register", h.Register)
 	router.POST("/login", h.Login)
-	router.PUT("/users/:id", h.UpdateUser)
-	router.DELETE("/users/:id", h.DeleteUser)
+	router.PUT("/users/:id", h.auth.Middleware(), authkit.RequireSelf("id"), h.UpdateUser)
+	router.DELETE("/users/:id", h.auth.Middleware(), authkit.RequireSelf("id"), h.DeleteUser)
+	router.POST("/users/:id/deactivate", h.auth.Middleware(), authkit.RequireSelf("id"), h.DeactivateUser)
+	router.POST("/users/restore", h.RestoreUser)
+	router.GET("/users/:id/export", h.auth.Middleware(), authkit.RequireSelf("id"), h.RequestExport)
+	router.GET("/users/:id/export/:job_id", h.auth.Middleware(), authkit.RequireSelf("id"), h.GetExport)
+	router.GET("/users/:id/export/:job_id/download", h.auth.Middleware(), authkit.RequireSelf("id"), h.DownloadExport)
+	router.DELETE("/admin/users/:id", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.DeleteUser)
+	router.POST("/admin/users/:id/restore", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.AdminRestoreUser)
+	router.GET("/admin/users/:id/export", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.RequestExport)
+	router.GET("/admin/users/:id/export/:job_id", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.GetExport)
+	router.GET("/admin/users/:id/export/:job_id/download", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.DownloadExport)
+	router.GET("/users", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin), h.ListUsers)
 	router.GET("/users/:id", h.GetUser)
+	router.GET("/users/:id/history", h.auth.Middleware(), authkit.RequireRole(authkit.RoleAdmin, authkit.RoleModerator), h.GetHistory)
+	router.POST("/users/:id/verify/:channel/send", h.auth.Middleware(), authkit.RequireSelf("id"), h.SendVerificationCode)
+	router.POST("/users/:id/verify/:channel/confirm", h.auth.Middleware(), authkit.RequireSelf("id"), h.ConfirmVerification)
 	router.GET("/users/check-login/:login", h.CheckLoginExists)
 	router.GET("/users/check-email/:email", h.CheckEmailExists)
 	router.GET("/users/check-phone/:phone", h.CheckPhoneExists)
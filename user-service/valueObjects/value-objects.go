@@ -1,11 +1,10 @@
 package valueObjects
 
 import (
+	"auth-kit"
 	"net/mail"
 	"regexp"
 	errs "user-service/errors"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Email struct {
@@ -52,13 +51,20 @@ type Password struct {
 	value string
 }
 
+// passwordHasher заменяется при старте сервиса хешером из настроек окружения
+var passwordHasher authkit.PasswordHasher = authkit.NewArgon2idHasher(authkit.DefaultArgon2Params)
+
+func SetPasswordHasher(hasher authkit.PasswordHasher) {
+	passwordHasher = hasher
+}
+
 func NewPassword(value string) (Password, error) {
 	if isValidPassword(value) {
-		passwordHash, err := bcrypt.GenerateFromPassword([]byte(value), bcrypt.DefaultCost)
+		passwordHash, err := passwordHasher.Hash(value)
 		if err != nil {
 			return Password{""}, err
 		}
-		return Password{string(passwordHash)}, nil
+		return Password{passwordHash}, nil
 	}
 	return Password{}, errs.ErrInvalidPassword
 }